@@ -0,0 +1,117 @@
+package jsontools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	// ErrUnexpectedEOF is reported when the input ends in the middle of json.
+	ErrUnexpectedEOF = errors.New("unexpected EOF")
+	// ErrInvalidToken is reported on a token which is malformed or not expected here.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrInvalidNumber is reported when a number doesn't match the RFC 8259 grammar.
+	ErrInvalidNumber = errors.New("invalid number")
+	// ErrInvalidUTF8 is reported on invalid utf8 inside a string.
+	ErrInvalidUTF8 = errors.New("invalid utf8")
+	// ErrDepthExceeded is reported when objects and arrays are nested too deep.
+	ErrDepthExceeded = errors.New("max depth exceeded")
+	// ErrInvalidPath is reported by JsonModifier configured with a malformed path.
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrEmptySecret is reported by JsonModifier configured with an empty
+	// secret of WithHashKeys, which makes the hashes unkeyed.
+	ErrEmptySecret = errors.New("empty secret")
+	// ErrOutputTooSmall is reported by JsonModifier when not even an empty
+	// object or array fits in WithMaxOutputBytes.
+	ErrOutputTooSmall = errors.New("max output bytes too small")
+	// ErrBodyTooLarge is reported by NewHttpMiddleware and NewHttpRoundTripper
+	// when the body is larger than the max body bytes.
+	ErrBodyTooLarge = errors.New("body too large")
+)
+
+// excerptSize is the number of bytes kept on each side of the error offset.
+const excerptSize = 16
+
+// SyntaxError describes where and why the input is not valid json.
+// Err is one of ErrUnexpectedEOF, ErrInvalidToken, ErrInvalidNumber,
+// ErrInvalidUTF8 and ErrDepthExceeded.
+type SyntaxError struct {
+	Msg     string
+	Offset  int64  // byte offset of the error in the input
+	Line    int    // 1-based line of the error
+	Column  int    // 1-based byte column of the error
+	Excerpt string // input surrounding the error
+	Err     error  // underlying error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d (offset %d) near %q", e.Msg, e.Line, e.Column, e.Offset, e.Excerpt)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerError wraps the error returned by the handler of parser, to tell it
+// apart from the errors of invalid input.
+type HandlerError struct {
+	Offset int64 // byte offset of the token passed to the handler
+	Err    error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("handler error at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// syntaxError creates a SyntaxError at data[off].
+func (t *jsonTokenizer) syntaxError(off int, err error, msg string) *SyntaxError {
+	if off < 0 {
+		off = 0
+	}
+	if off > len(t.data) {
+		off = len(t.data)
+	}
+
+	line := t.lines + 1 + bytes.Count(t.data[:off], []byte{'\n'})
+	lineStart := t.lineStart
+	if i := bytes.LastIndexByte(t.data[:off], '\n'); i >= 0 {
+		lineStart = t.base + int64(i) + 1
+	}
+	offset := t.base + int64(off)
+
+	return &SyntaxError{
+		Msg:     msg,
+		Offset:  offset,
+		Line:    line,
+		Column:  int(offset-lineStart) + 1,
+		Excerpt: excerpt(t.data, off),
+		Err:     err,
+	}
+}
+
+// excerpt returns at most excerptSize bytes on each side of data[off],
+// without splitting runes.
+func excerpt(data []byte, off int) string {
+	begin := off - excerptSize
+	if begin < 0 {
+		begin = 0
+	}
+	for begin < off && !utf8.RuneStart(data[begin]) {
+		begin++
+	}
+	end := off + excerptSize
+	if end >= len(data) {
+		end = len(data)
+	} else {
+		for end > off && !utf8.RuneStart(data[end]) {
+			end--
+		}
+	}
+	return string(data[begin:end])
+}
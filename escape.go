@@ -0,0 +1,194 @@
+package jsontools
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// appendUnquoted appends the decoded content of the json string s, which
+// includes the quotes, to dst. Malformed escapes are kept as they are.
+func appendUnquoted(dst, s []byte) []byte {
+	if len(s) >= 2 {
+		s = s[1 : len(s)-1]
+	}
+	if bytes.IndexByte(s, '\\') < 0 {
+		return append(dst, s...)
+	}
+
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			j := bytes.IndexByte(s[i:], '\\')
+			if j < 0 {
+				return append(dst, s[i:]...)
+			}
+			dst = append(dst, s[i:i+j]...)
+			i += j
+			continue
+		}
+		r, size := decodeEscape(s[i:])
+		if size == 0 {
+			// malformed escape
+			dst = append(dst, s[i])
+			i++
+			continue
+		}
+		dst = utf8.AppendRune(dst, r)
+		i += size
+	}
+	return dst
+}
+
+// decodeEscape decodes the escape sequence at the beginning of s, a
+// surrogate pair of \uXXXX escapes is decoded as a single rune. It returns
+// size 0 if s doesn't start with a valid escape sequence.
+func decodeEscape(s []byte) (rune, int) {
+	if len(s) < 2 || s[0] != '\\' {
+		return utf8.RuneError, 0
+	}
+	switch s[1] {
+	case '"', '\\', '/':
+		return rune(s[1]), 2
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'u':
+		r, ok := decodeHex4(s[2:])
+		if !ok {
+			return utf8.RuneError, 0
+		}
+		if utf16.IsSurrogate(r) {
+			if len(s) >= 12 && s[6] == '\\' && s[7] == 'u' {
+				if r2, ok := decodeHex4(s[8:]); ok {
+					if dec := utf16.DecodeRune(r, r2); dec != utf8.RuneError {
+						return dec, 12
+					}
+				}
+			}
+			return utf8.RuneError, 6
+		}
+		return r, 6
+	}
+	return utf8.RuneError, 0
+}
+
+func decodeHex4(s []byte) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+// appendQuoted appends s as a json string to dst.
+func appendQuoted(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	dst = appendEscaped(dst, s)
+	return append(dst, '"')
+}
+
+// appendEscaped appends s escaped as the content of a json string to dst,
+// invalid utf8 is replaced by U+FFFD.
+func appendEscaped(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\uFFFD"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	return append(dst, s[start:]...)
+}
+
+// unquotedLen returns the number of characters of the decoded json string s,
+// which includes the quotes.
+func unquotedLen(s []byte) int {
+	if len(s) >= 2 {
+		s = s[1 : len(s)-1]
+	}
+	n := 0
+	for i := 0; i < len(s); n++ {
+		i += escapedCharSize(s[i:])
+	}
+	return n
+}
+
+// escapedPrefixLen returns the length of the longest prefix of the json
+// string s, which includes the quotes, whose content fits in n bytes without
+// splitting a rune or an escape sequence. The length includes the opening
+// quote.
+func escapedPrefixLen(s []byte, n int) int {
+	if len(s) >= 2 {
+		s = s[1 : len(s)-1]
+	}
+	i := 0
+	for i < len(s) {
+		size := escapedCharSize(s[i:])
+		if i+size > n {
+			break
+		}
+		i += size
+	}
+	return i + 1
+}
+
+// escapedCharSize returns the size of the first character of the escaped
+// content s, which is an escape sequence or a rune.
+func escapedCharSize(s []byte) int {
+	if s[0] == '\\' {
+		if _, size := decodeEscape(s); size > 0 {
+			return size
+		}
+	}
+	_, size := utf8.DecodeRune(s)
+	return size
+}
@@ -0,0 +1,216 @@
+package jsontools
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HttpLog is passed to the callback of NewHttpMiddleware and
+// NewHttpRoundTripper for every request.
+type HttpLog struct {
+	Request *http.Request
+	Status  int           // status code, 0 if the round trip fails
+	Latency time.Duration // until the handler returns, or until the response header is received by the client
+	Err     error         // error of the round trip, client only
+
+	// The json bodies modified by the JsonModifier, nil if the body is not
+	// json by Content-Type, or can't be logged for RequestBodyErr and
+	// ResponseBodyErr, which are ErrBodyTooLarge, the read error, or the
+	// sentinel error of invalid json, without the excerpt of the raw body.
+	RequestBody     []byte
+	RequestBodyErr  error
+	ResponseBody    []byte
+	ResponseBodyErr error
+}
+
+// NewHttpMiddleware returns a middleware which passes the json bodies of
+// the request and the response, modified by m, to callback. The bodies
+// larger than maxBodyBytes are not captured. It panics if callback is nil.
+func NewHttpMiddleware(m *JsonModifier, maxBodyBytes int, callback func(*HttpLog)) func(http.Handler) http.Handler {
+	if callback == nil {
+		panic("jsontools: nil callback")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			log := &HttpLog{Request: r}
+			if isJsonContentType(r.Header) && r.Body != nil && r.Body != http.NoBody {
+				var body []byte
+				body, r.Body, log.RequestBodyErr = peekBody(r.Body, maxBodyBytes)
+				if log.RequestBodyErr == nil {
+					log.RequestBody, log.RequestBodyErr = modifyBody(m, body)
+				}
+			}
+
+			rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK, limit: maxBodyBytes}
+			next.ServeHTTP(rw, r)
+			log.Latency = time.Since(start)
+			log.Status = rw.status
+
+			if isJsonContentType(w.Header()) {
+				if rw.exceeded {
+					log.ResponseBodyErr = ErrBodyTooLarge
+				} else {
+					log.ResponseBody, log.ResponseBodyErr = modifyBody(m, rw.body)
+				}
+			}
+			callback(log)
+		})
+	}
+}
+
+// NewHttpRoundTripper returns a http.RoundTripper which passes the json
+// bodies of the request and the response, modified by m, to callback. The
+// bodies larger than maxBodyBytes are not captured. http.DefaultTransport
+// is used if next is nil. It panics if callback is nil.
+func NewHttpRoundTripper(next http.RoundTripper, m *JsonModifier, maxBodyBytes int, callback func(*HttpLog)) http.RoundTripper {
+	if callback == nil {
+		panic("jsontools: nil callback")
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &httpRoundTripper{next: next, m: m, limit: maxBodyBytes, callback: callback}
+}
+
+type httpRoundTripper struct {
+	next     http.RoundTripper
+	m        *JsonModifier
+	limit    int
+	callback func(*HttpLog)
+}
+
+func (t *httpRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	log := &HttpLog{Request: req}
+	if isJsonContentType(req.Header) && req.Body != nil && req.Body != http.NoBody {
+		// the request should not be modified by RoundTripper
+		req = req.Clone(req.Context())
+		var body []byte
+		body, req.Body, log.RequestBodyErr = peekBody(req.Body, t.limit)
+		if log.RequestBodyErr == nil {
+			log.RequestBody, log.RequestBodyErr = modifyBody(t.m, body)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	log.Latency = time.Since(start)
+	if err != nil {
+		log.Err = err
+		t.callback(log)
+		return nil, err
+	}
+
+	log.Status = resp.StatusCode
+	if isJsonContentType(resp.Header) && resp.Body != nil && resp.Body != http.NoBody {
+		var body []byte
+		body, resp.Body, log.ResponseBodyErr = peekBody(resp.Body, t.limit)
+		if log.ResponseBodyErr == nil {
+			log.ResponseBody, log.ResponseBodyErr = modifyBody(t.m, body)
+		}
+	}
+	t.callback(log)
+	return resp, nil
+}
+
+// peekBody reads at most limit bytes of body, and returns them with a body
+// which reads all of the original body again.
+func peekBody(body io.ReadCloser, limit int) ([]byte, io.ReadCloser, error) {
+	buf, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), body), body}
+	if err != nil {
+		return nil, rest, err
+	}
+	if len(buf) > limit {
+		return nil, rest, ErrBodyTooLarge
+	}
+	return buf, rest, nil
+}
+
+// modifyBody modifies the json body by m. The error of invalid json is
+// replaced with its sentinel error, since SyntaxError has an excerpt of the
+// body.
+func modifyBody(m *JsonModifier, body []byte) ([]byte, error) {
+	dst, err := m.AppendModified(nil, body)
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return nil, syntaxErr.Err
+	}
+	return dst, err
+}
+
+func isJsonContentType(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// responseRecorder records the status and at most limit bytes of the body
+// written to the http.ResponseWriter.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        []byte
+	limit       int
+	exceeded    bool
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	if !w.exceeded {
+		if len(w.body)+len(p) > w.limit {
+			w.exceeded = true
+			w.body = nil
+		} else {
+			w.body = append(w.body, p...)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is used by the websocket upgrades, the body written to the
+// hijacked connection is not recorded.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.wroteHeader {
+		w.status = http.StatusSwitchingProtocols
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap is used by http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
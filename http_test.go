@@ -0,0 +1,167 @@
+package jsontools_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/WqyJh/jsontools"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpMiddleware(t *testing.T) {
+	modifier := jsontools.NewJsonModifier(jsontools.WithFilterKeys("password"), jsontools.WithFieldLengthLimit(5))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", r.Header.Get("Accept"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body) // echo
+	})
+
+	cases := []struct {
+		body        string
+		contentType string
+		accept      string
+		expected    string
+		err         error
+	}{
+		{`{"user":"1234567890","password":"x"}`, "application/json", "application/json; charset=utf-8", `{"user":"12345"}`, nil},
+		{`{"user":"1234567890","password":"x"}`, "application/vnd.api+json", "text/plain", `{"user":"12345"}`, nil},
+		{`{"user":"1234567890","password":"x"}`, "text/plain", "text/plain", ``, nil},
+		{`{"user":"1234567890","password":"x"}` + strings.Repeat(" ", 64), "application/json", "application/json", ``, jsontools.ErrBodyTooLarge},
+		{`{"user":"1234567890","password":`, "application/json", "application/json", ``, jsontools.ErrUnexpectedEOF},
+	}
+	for _, c := range cases {
+		var log *jsontools.HttpLog
+		middleware := jsontools.NewHttpMiddleware(modifier, 64, func(l *jsontools.HttpLog) {
+			log = l
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.contentType)
+		req.Header.Set("Accept", c.accept)
+		rec := httptest.NewRecorder()
+		middleware(handler).ServeHTTP(rec, req)
+
+		// the handler and the client see the original body
+		require.Equal(t, c.body, rec.Body.String())
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		require.NotNil(t, log)
+		require.Equal(t, http.StatusCreated, log.Status)
+		require.Equal(t, "/users", log.Request.URL.Path)
+		require.ErrorIs(t, log.RequestBodyErr, c.err)
+		if c.contentType != "text/plain" {
+			require.Equal(t, c.expected, string(log.RequestBody))
+		} else {
+			require.Nil(t, log.RequestBody)
+		}
+		if c.accept != "text/plain" {
+			require.ErrorIs(t, log.ResponseBodyErr, c.err)
+			require.Equal(t, c.expected, string(log.ResponseBody))
+		} else {
+			require.NoError(t, log.ResponseBodyErr)
+			require.Nil(t, log.ResponseBody)
+		}
+		if c.err != nil {
+			// the raw body is never exposed by the error
+			require.NotContains(t, log.RequestBodyErr.Error(), "password")
+		}
+	}
+}
+
+func TestHttpRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	var log *jsontools.HttpLog
+	modifier := jsontools.NewJsonModifier(jsontools.WithRedactKeys("***", "token"))
+	client := &http.Client{Transport: jsontools.NewHttpRoundTripper(nil, modifier, 1024, func(l *jsontools.HttpLog) {
+		log = l
+	})}
+
+	body := `{"id":1,"token":"abc"}`
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Equal(t, body, string(respBody))
+	require.Equal(t, http.StatusOK, log.Status)
+	require.Equal(t, `{"id":1,"token":"***"}`, string(log.RequestBody))
+	require.Equal(t, `{"id":1,"token":"***"}`, string(log.ResponseBody))
+	require.NoError(t, log.Err)
+
+	// round trip error
+	server.Close()
+	_, err = client.Get(server.URL)
+	require.Error(t, err)
+	require.Error(t, log.Err)
+	require.Equal(t, 0, log.Status)
+}
+
+func TestHttpMiddlewareHijackFlush(t *testing.T) {
+	modifier := jsontools.NewJsonModifier()
+	var log *jsontools.HttpLog
+	middleware := jsontools.NewHttpMiddleware(modifier, 64, func(l *jsontools.HttpLog) {
+		log = l
+	})
+
+	// streaming handlers can flush
+	rec := httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.True(t, rec.Flushed)
+	require.Equal(t, http.StatusOK, log.Status)
+
+	// websocket upgrades can hijack the connection
+	logs := make(chan *jsontools.HttpLog, 1)
+	server := httptest.NewServer(jsontools.NewHttpMiddleware(modifier, 64, func(l *jsontools.HttpLog) {
+		logs <- l
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\nhello")
+		require.NoError(t, rw.Flush())
+	})))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	line, err := bufio.NewReader(resp.Body).ReadString('o')
+	require.NoError(t, err)
+	require.Equal(t, "hello", line)
+	require.Equal(t, http.StatusSwitchingProtocols, (<-logs).Status)
+
+	// hijacking is not supported by the recorder
+	rec = httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err := w.(http.Hijacker).Hijack()
+		require.ErrorIs(t, err, http.ErrNotSupported)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestHttpNilCallback(t *testing.T) {
+	modifier := jsontools.NewJsonModifier()
+	require.Panics(t, func() { jsontools.NewHttpMiddleware(modifier, 64, nil) })
+	require.Panics(t, func() { jsontools.NewHttpRoundTripper(nil, modifier, 64, nil) })
+}
@@ -0,0 +1,122 @@
+package jsontools
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// keyMatcher matches the decoded object keys by exact names, glob patterns
+// and regexps.
+type keyMatcher struct {
+	exact   map[string]struct{}
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func (k *keyMatcher) addKeys(keys ...string) {
+	if k.exact == nil {
+		k.exact = make(map[string]struct{}, len(keys))
+	}
+	for _, key := range keys {
+		k.exact[key] = struct{}{}
+	}
+}
+
+func (k *keyMatcher) empty() bool {
+	return len(k.exact) == 0 && len(k.globs) == 0 && len(k.regexps) == 0
+}
+
+// fold lowers the exact names and glob patterns, for matching the lowered
+// keys. The regexps are not affected, use (?i) for them.
+func (k *keyMatcher) fold() {
+	if k.exact != nil {
+		exact := make(map[string]struct{}, len(k.exact))
+		for key := range k.exact {
+			exact[strings.ToLower(key)] = struct{}{}
+		}
+		k.exact = exact
+	}
+	for i, glob := range k.globs {
+		k.globs[i] = strings.ToLower(glob)
+	}
+}
+
+// match reports whether key matches. key is lowered if folded, and the
+// regexps are matched against the original key.
+func (k *keyMatcher) match(key, original []byte) bool {
+	if _, ok := k.exact[string(key)]; ok {
+		return true
+	}
+	for _, glob := range k.globs {
+		if matchGlob(glob, key) {
+			return true
+		}
+	}
+	for _, re := range k.regexps {
+		if re.Match(original) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether s matches the glob pattern, in which '*'
+// matches any sequence of characters and '?' matches one character.
+func matchGlob(pattern string, s []byte) bool {
+	// position to restart from on mismatch, after the last '*'
+	starPattern, starS := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starPattern, starS = p, i
+				p++
+				continue
+			case '?':
+				_, size := utf8.DecodeRune(s[i:])
+				p++
+				i += size
+				continue
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if starPattern < 0 {
+			return false
+		}
+		// let the last '*' match one more character
+		_, size := utf8.DecodeRune(s[starS:])
+		starS += size
+		p, i = starPattern+1, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// appendLower appends the lower case of s to dst.
+func appendLower(dst []byte, s []byte) []byte {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			dst = append(dst, c)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		dst = utf8.AppendRune(dst, unicode.ToLower(r))
+		i += size
+	}
+	return dst
+}
@@ -1,14 +1,83 @@
 package jsontools
 
 import (
-	"errors"
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"unicode/utf8"
 )
 
 type JsonModifier struct {
 	limit        int
+	keyLimits    map[string]int // limits of the values of keys
+	pathLimits   []pathLimit
+	byteLimit    int
 	inplace      bool
-	filterKeySet map[string]struct{}
+	foldKeys     bool // match keys case-insensitively
+	pathKeys     bool // some option matches keys, which are decoded by parser
+	members      bool // some option filters or replaces members and elements
+	filterKeys   keyMatcher
+	filterPaths  []pathPattern
+	allow        bool // keep only allowKeys and allowPaths
+	allowKeys    keyMatcher
+	allowPaths   []pathPattern
+	redactKeys   keyMatcher
+	redact       placeholder
+	hashSecret   []byte
+	hashKeys     keyMatcher
+	hashPaths    []pathPattern
+	hashLen      int // hex characters of the hash
+	detectors    []Detector
+	mask         placeholder // replacement of detected substrings
+	suffix       placeholder // appended to truncated strings
+	arrayLimit   int
+	arrayMarker  *placeholder // appended to truncated arrays
+	maxDepth     int
+	depthObject  []byte // replacement of too deep objects
+	depthArray   []byte // replacement of too deep arrays
+	maxOutput    int
+	outputMarker []byte // quoted marker of omitted content
+	err          error  // error of options, returned by ModifyJson
+}
+
+// placeholder is the escaped content of a json string, which may contain a
+// number, eg: "[REDACTED len={len}]".
+type placeholder struct {
+	before []byte
+	after  []byte
+	hasNum bool
+}
+
+// newPlaceholder replaces the first name in s with the number.
+func newPlaceholder(s string, name string) placeholder {
+	before, after, found := strings.Cut(s, name)
+	return placeholder{
+		before: appendEscaped(nil, before),
+		after:  appendEscaped(nil, after),
+		hasNum: found,
+	}
+}
+
+// size returns the size of the placeholder with number n.
+func (p placeholder) size(n int) int {
+	size := len(p.before) + len(p.after)
+	if p.hasNum {
+		size += len(strconv.Itoa(n))
+	}
+	return size
+}
+
+func (p placeholder) appendTo(dst []byte, n int) []byte {
+	dst = append(dst, p.before...)
+	if p.hasNum {
+		dst = strconv.AppendInt(dst, int64(n), 10)
+	}
+	return append(dst, p.after...)
 }
 
 type JsonModifierOption func(*JsonModifier)
@@ -19,126 +88,1070 @@ func WithFieldLengthLimit(limit int) JsonModifierOption {
 	}
 }
 
+// WithFieldLengthLimits sets the length limits of the strings by key or by
+// path, eg: {"stack_trace": 2000, "$.request.body": 200}. A key limits the
+// string values of the key at any level, an entry starting with '$' is a
+// path in the syntax of WithFilterPaths. Paths are matched before keys, in
+// lexical order, the strings not matched are limited by WithFieldLengthLimit.
+// Limit 0 means no limit.
+func WithFieldLengthLimits(limits map[string]int) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.keyLimits = make(map[string]int, len(limits))
+		m.pathLimits = nil
+		for key, limit := range limits {
+			if !strings.HasPrefix(key, "$") {
+				m.keyLimits[key] = limit
+				continue
+			}
+			pattern, err := compilePath(key)
+			if err != nil {
+				m.err = err
+				return
+			}
+			m.pathLimits = append(m.pathLimits, pathLimit{expr: key, pattern: pattern, limit: limit})
+		}
+		sort.Slice(m.pathLimits, func(i, j int) bool {
+			return m.pathLimits[i].expr < m.pathLimits[j].expr
+		})
+	}
+}
+
+// WithFieldByteLimit truncates the strings whose escaped content is longer
+// than limit bytes, at the last rune or escape sequence that fits. The suffix
+// of WithTruncateSuffix counts in the limit, and is omitted if it doesn't
+// fit. It can be used together with WithFieldLengthLimit, the shorter result
+// wins.
+func WithFieldByteLimit(limit int) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.byteLimit = limit
+	}
+}
+
 func WithInplace(inplace bool) JsonModifierOption {
 	return func(m *JsonModifier) {
 		m.inplace = inplace
 	}
 }
 
+// WithFilterKeys filters the members of keys at any level, the keys are
+// matched after decoding escapes, eg: "pass\u0077ord" matches "password".
 func WithFilterKeys(keys ...string) JsonModifierOption {
 	return func(m *JsonModifier) {
-		m.filterKeySet = make(map[string]struct{}, len(keys))
+		m.filterKeys.exact = nil
+		m.filterKeys.addKeys(keys...)
+	}
+}
+
+// WithFilterKeyGlobs filters the members whose keys match any of the glob
+// patterns, in which '*' matches any sequence of characters and '?' matches
+// one character, eg: "*token*".
+func WithFilterKeyGlobs(globs ...string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.filterKeys.globs = append([]string(nil), globs...)
+	}
+}
+
+// WithFilterKeyRegexps filters the members whose keys match any of the
+// regexps, eg: regexp.MustCompile(`(?i)^x-api-key$`).
+func WithFilterKeyRegexps(regexps ...*regexp.Regexp) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.filterKeys.regexps = regexps
+	}
+}
+
+// WithKeyCaseInsensitive matches the keys of WithFilterKeys,
+// WithFilterKeyGlobs, WithAllowKeys, WithRedactKeys, WithHashKeys and
+// WithFieldLengthLimits case-insensitively. Paths and regexps are not
+// affected, use (?i) for regexps.
+func WithKeyCaseInsensitive(fold bool) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.foldKeys = fold
+	}
+}
+
+// WithFilterPaths filters the values at paths, in a subset of JSONPath:
+// exact paths ($.auth.token, $.items[0]), wildcards ($.users[*].password,
+// $.users.*) and recursive descent ($..secret). Both object members and
+// array elements can be filtered.
+func WithFilterPaths(paths ...string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		patterns, err := compilePaths(paths)
+		if err != nil {
+			m.err = err
+			return
+		}
+		m.filterPaths = patterns
+	}
+}
+
+// WithAllowKeys keeps only the members of keys at any level and the values
+// at paths, a key starting with '$' is a path in the syntax of
+// WithFilterPaths. The ancestors of the kept values are kept, everything
+// else is dropped, eg: with "id" and "$.error.code", {"id":1,"error":
+// {"code":2,"msg":"x"},"user":{"name":"y"}} becomes {"id":1,"error":
+// {"code":2}}. The ancestors replaced by WithRedactKeys, WithHashKeys or
+// WithMaxDepth are dropped, since the replacement contains no allowed values.
+func WithAllowKeys(keys ...string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.allow = true
+		m.allowKeys.exact = nil
+		m.allowPaths = nil
 		for _, key := range keys {
-			k := `"` + key + `"`
-			m.filterKeySet[k] = struct{}{}
+			if !strings.HasPrefix(key, "$") {
+				m.allowKeys.addKeys(key)
+				continue
+			}
+			pattern, err := compilePath(key)
+			if err != nil {
+				m.err = err
+				return
+			}
+			m.allowPaths = append(m.allowPaths, pattern)
 		}
 	}
 }
 
+// WithRedactKeys keeps the keys but replaces their values, whether scalar,
+// object or array, with the string replacement, eg: "***". The first
+// "{len}" in replacement is replaced with the length of the original value,
+// in characters for strings and in bytes of compact json for others,
+// eg: "[REDACTED len={len}]".
+func WithRedactKeys(replacement string, keys ...string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.redactKeys.exact = nil
+		m.redactKeys.addKeys(keys...)
+		m.redact = newPlaceholder(replacement, "{len}")
+	}
+}
+
+// WithHashKeys replaces the values of keys with a pseudonym, which is the
+// hex of HMAC-SHA256 keyed by secret, truncated by WithHashLength. The hash
+// is computed over the decoded string, or the compact json of other values
+// with the strings re-encoded, so equal values get equal pseudonyms however
+// they are spaced and escaped, while the numbers and the order of members
+// are hashed as they are. A key starting with '$' is a path
+// in the syntax of WithFilterPaths. The secret must not be empty, since the
+// unkeyed hashes of low-entropy values, such as emails and ids, can be
+// reversed by brute force, ErrEmptySecret is returned otherwise.
+func WithHashKeys(secret []byte, keys ...string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		if len(secret) == 0 {
+			m.err = ErrEmptySecret
+			return
+		}
+		m.hashSecret = secret
+		m.hashKeys.exact = nil
+		m.hashPaths = nil
+		for _, key := range keys {
+			if !strings.HasPrefix(key, "$") {
+				m.hashKeys.addKeys(key)
+				continue
+			}
+			pattern, err := compilePath(key)
+			if err != nil {
+				m.err = err
+				return
+			}
+			m.hashPaths = append(m.hashPaths, pattern)
+		}
+	}
+}
+
+// WithHashLength sets the number of hex characters of the hash of
+// WithHashKeys, 16 by default. n is clamped into 1 to 64.
+func WithHashLength(n int) JsonModifierOption {
+	return func(m *JsonModifier) {
+		switch {
+		case n < 1:
+			m.hashLen = 1
+		case n > 2*sha256.Size:
+			m.hashLen = 2 * sha256.Size
+		default:
+			m.hashLen = n
+		}
+	}
+}
+
+// WithDetectors scans the string values with detectors, and replaces the
+// detected substrings with mask, eg: "***". The first "{len}" in mask is
+// replaced with the number of the masked characters. DefaultDetectors has
+// all the built-in detectors, and RegexpDetector detects any pattern.
+func WithDetectors(mask string, detectors ...Detector) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.detectors = detectors
+		m.mask = newPlaceholder(mask, "{len}")
+	}
+}
+
+// WithTruncateSuffix appends suffix to the strings truncated by
+// WithFieldLengthLimit or WithFieldByteLimit, after the kept characters. The
+// first "{more}" in suffix is replaced with the number of the cut characters,
+// or the cut bytes if cut by WithFieldByteLimit, eg: "...(+{more} chars)".
+func WithTruncateSuffix(suffix string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.suffix = newPlaceholder(suffix, "{more}")
+	}
+}
+
+// WithArrayLengthLimit keeps only the first limit elements of arrays, the
+// elements removed by the filters don't count.
+func WithArrayLengthLimit(limit int) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.arrayLimit = limit
+	}
+}
+
+// WithArrayTruncateMarker appends the string marker as the last element of
+// the arrays truncated by WithArrayLengthLimit. The first "{more}" in marker
+// is replaced with the number of the dropped elements, eg: "...({more} more)".
+func WithArrayTruncateMarker(marker string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		p := newPlaceholder(marker, "{more}")
+		m.arrayMarker = &p
+	}
+}
+
+// WithMaxDepth keeps depth levels of objects and arrays, the deeper ones are
+// replaced with "{...}" and "[...]", eg: {"a":{"b":{}}} is modified to
+// {"a":"{...}"} with depth 1.
+func WithMaxDepth(depth int) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.maxDepth = depth
+	}
+}
+
+// WithMaxDepthPlaceholders sets the replacements of the objects and arrays
+// deeper than WithMaxDepth. They are written as they are, and must be valid
+// json, eg: `{}` and `[]` for empty containers.
+func WithMaxDepthPlaceholders(object, array string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.depthObject = []byte(object)
+		m.depthArray = []byte(array)
+	}
+}
+
+// WithMaxOutputBytes limits the output to n bytes, while keeping it valid
+// json. The members and elements which don't fit are omitted, a marker is
+// appended to the innermost open object or array as "...":"..." or "...",
+// and the open ones are closed. If the marker doesn't fit, the open ones are
+// closed without it, and ErrOutputTooSmall is returned only if not even an
+// empty object or array fits. The rest of the input is still validated.
+func WithMaxOutputBytes(n int) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.maxOutput = n
+	}
+}
+
+// WithOutputTruncateMarker sets the marker of WithMaxOutputBytes, which is
+// "..." by default.
+func WithOutputTruncateMarker(marker string) JsonModifierOption {
+	return func(m *JsonModifier) {
+		m.outputMarker = appendQuoted(nil, marker)
+	}
+}
+
 func NewJsonModifier(opts ...JsonModifierOption) *JsonModifier {
-	m := &JsonModifier{}
+	m := &JsonModifier{
+		depthObject:  []byte(`"{...}"`),
+		depthArray:   []byte(`"[...]"`),
+		outputMarker: []byte(`"..."`),
+		hashLen:      16,
+	}
 	for _, opt := range opts {
 		opt(m)
 	}
+	if m.foldKeys {
+		m.filterKeys.fold()
+		m.allowKeys.fold()
+		m.redactKeys.fold()
+		m.hashKeys.fold()
+		keyLimits := make(map[string]int, len(m.keyLimits))
+		for key, limit := range m.keyLimits {
+			keyLimits[strings.ToLower(key)] = limit
+		}
+		m.keyLimits = keyLimits
+	}
+	m.pathKeys = !m.filterKeys.empty() || len(m.filterPaths) > 0 ||
+		!m.allowKeys.empty() || len(m.allowPaths) > 0 ||
+		!m.redactKeys.empty() || !m.hashKeys.empty() || len(m.hashPaths) > 0 ||
+		len(m.keyLimits) > 0 || len(m.pathLimits) > 0
+	m.members = m.pathKeys || m.allow || m.arrayLimit > 0 || m.maxDepth > 0
 	return m
 }
 
 func (m *JsonModifier) ModifyJson(data []byte) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	if len(data) == 0 {
 		return data, nil
 	}
 
-	var dst []byte
 	if m.inplace {
-		dst = data[:0]
+		return m.appendModified(data[:0], data, true)
+	}
+	return m.appendModified(make([]byte, 0, len(data)), data, false)
+}
+
+// AppendModified appends the modified json of src to dst and returns the
+// extended buffer, like strconv.AppendInt, so that dst could be reused.
+// src is never modified, WithInplace is ignored. On error, dst is returned
+// as it is.
+func (m *JsonModifier) AppendModified(dst, src []byte) ([]byte, error) {
+	if m.err != nil {
+		return dst, m.err
+	}
+	if len(src) == 0 {
+		return dst, nil
+	}
+	result, err := m.appendModified(dst, src, false)
+	if err != nil {
+		return dst, err
+	}
+	return result, nil
+}
+
+func (m *JsonModifier) appendModified(dst, data []byte, inplace bool) ([]byte, error) {
+	state := modifyState{
+		m:           m,
+		data:        data,
+		dst:         dst,
+		inplace:     inplace,
+		head:        len(dst),
+		safeLen:     len(dst),
+		bareSafeLen: len(dst),
+	}
+
+	parser := NewJsonParser(data, state.handle)
+	parser.SetPathKeys(m.pathKeys)
+	err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if state.cut && len(state.dst) == state.head {
+		return nil, ErrOutputTooSmall
+	}
+	return state.dst, nil
+}
+
+type replaceKind byte
+
+const (
+	replaceNone   replaceKind = iota
+	replaceRedact             // redacted value
+	replaceDepth              // too deep object or array
+	replaceHash               // pseudonymized value
+)
+
+// modifyState is the state of a single ModifyJson call.
+type modifyState struct {
+	m       *JsonModifier
+	data    []byte
+	dst     []byte
+	head    int // length of dst before the output, by AppendModified
+	inplace bool
+
+	// writer of ModifyTo and ModifyStream, and its error
+	w    io.Writer
+	werr error
+
+	// skipping the tokens whose path is not shorter than skipPathLen
+	skipPathLen int
+
+	// dropped elements of the array whose path length is droppedPathLen,
+	// only one array could be dropping elements at the same time, since
+	// the dropped elements are skipped entirely
+	dropped        int
+	droppedPathLen int
+	// kept elements of the open arrays, indexed by the path length of the
+	// array
+	elements []int
+
+	// the allowed value whose path length is allowedPathLen is being kept,
+	// 0 if none
+	allowedPathLen int
+	// the objects and arrays which may contain allowed values, they are
+	// rolled back at their end if they don't
+	allowMarks []allowMark
+
+	// replacing the value whose path length is replacePathLen, the value
+	// is written at its end. replaceSize is the compact size of the object
+	// or array so far, or -1 if the value is not begun.
+	replacing      replaceKind
+	replacePathLen int
+	replaceSize    int
+
+	// hmac of the value being hashed, created on first use
+	hash    hash.Hash
+	hashBuf []byte
+
+	// lowered key of WithKeyCaseInsensitive
+	keyBuf []byte
+
+	// buffers of masking detected substrings
+	decoded []byte
+	masked  []byte
+	matches [][]int
+
+	// the token written by the last modify, Init if nothing is written
+	written TokenType
+	// closers of the open objects and arrays in dst
+	closers []byte
+	// dst length after the last complete value or open object or array,
+	// where dst could be cut with the marker
+	safeLen int
+	// same as safeLen, but where dst could be cut without the marker
+	bareSafeLen int
+	// dst is cut by maxOutput, the rest tokens are ignored
+	cut bool
+}
+
+// reserve makes sure that writing n bytes to dst won't overwrite the
+// unread input after end in inplace mode, by moving dst to a new buffer.
+func (s *modifyState) reserve(n int, end int64) {
+	if s.inplace && int64(len(s.dst)+n) > end {
+		s.dst = append(make([]byte, 0, len(s.data)+n), s.dst...)
+		s.inplace = false
+	}
+}
+
+func (s *modifyState) handle(ctx HandlerContext) error {
+	if s.m.maxOutput <= 0 {
+		s.modify(ctx)
+		return nil
+	}
+	if s.cut {
+		return nil
+	}
+
+	s.written = Init
+	s.modify(ctx)
+
+	switch s.written {
+	case Init:
+		return nil
+	case BeginObject:
+		s.closers = append(s.closers, '}')
+	case BeginArray:
+		s.closers = append(s.closers, ']')
+	case EndObject, EndArray:
+		s.closers = s.closers[:len(s.closers)-1]
+	}
+	if k := len(s.allowMarks); k > 0 && !s.allowMarks[k-1].kept {
+		// dst is tentative, it may be rolled back
+		return nil
+	}
+	if len(s.dst)-s.head+len(s.closers) > s.m.maxOutput {
+		s.cutOutput(ctx)
+		return nil
+	}
+
+	switch s.written {
+	case SepComma, SepColon:
+		return nil
+	case String:
+		if ctx.Kind == KindObjectKey {
+			return nil
+		}
+	}
+	if len(s.dst)-s.head+len(s.closers)+s.markerSize() <= s.m.maxOutput {
+		s.safeLen = len(s.dst)
+	}
+	s.bareSafeLen = len(s.dst)
+	return nil
+}
+
+// markerSize returns the size of the marker if dst is cut here.
+func (s *modifyState) markerSize() int {
+	if len(s.closers) == 0 {
+		return 0
+	}
+	size := len(s.m.outputMarker)
+	if s.closers[len(s.closers)-1] == '}' {
+		size = 2*size + 1
+	}
+	if last := s.dst[len(s.dst)-1]; last != '{' && last != '[' {
+		size++ // comma
+	}
+	return size
+}
+
+// cutOutput cuts dst at the last safe position, then appends the marker
+// and closes all open objects and arrays. If the marker never fits, dst is
+// cut at the last position where the closers fit, without the marker.
+func (s *modifyState) cutOutput(ctx HandlerContext) {
+	s.cut = true
+	withMarker := s.safeLen > s.head
+	if withMarker {
+		s.dst = s.dst[:s.safeLen]
 	} else {
-		dst = make([]byte, 0, len(data))
+		s.dst = s.dst[:s.bareSafeLen]
+	}
+	closers := appendClosers(nil, s.dst[s.head:])
+	if len(closers) == 0 {
+		return
+	}
+	if !withMarker {
+		s.reserve(len(closers), ctx.Offset+int64(len(ctx.Value)))
+		s.dst = append(s.dst, closers...)
+		return
 	}
 
-	skipComma := false
-	expectStackSize := 0
-	parser := NewJsonParser(data, func(ctx HandlerContext) error {
+	marker := s.m.outputMarker
+	s.reserve(2*len(marker)+2+len(closers), ctx.Offset+int64(len(ctx.Value)))
+	if last := s.dst[len(s.dst)-1]; last != '{' && last != '[' {
+		s.dst = append(s.dst, ',')
+	}
+	s.dst = append(s.dst, marker...)
+	if closers[0] == '}' {
+		s.dst = append(s.dst, ':')
+		s.dst = append(s.dst, marker...)
+	}
+	s.dst = append(s.dst, closers...)
+}
 
-		// filter keys ------- begin -------
-		if expectStackSize > 0 {
-			if ctx.StackSize >= expectStackSize {
-				// skip all colon, comma and values of this key
-				return nil
-			} else {
-				expectStackSize = 0
-				skipComma = true
+// appendClosers appends the closers of the open objects and arrays of the
+// compact json prefix data, the innermost first.
+func appendClosers(dst []byte, data []byte) []byte {
+	start := len(dst)
+	inString := false
+	escaped := false
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch c {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
 			}
+		case c == '"':
+			inString = true
+		case c == '{':
+			dst = append(dst, '}')
+		case c == '[':
+			dst = append(dst, ']')
+		case c == '}' || c == ']':
+			dst = dst[:len(dst)-1]
 		}
+	}
+	closers := dst[start:]
+	for i, j := 0, len(closers)-1; i < j; i, j = i+1, j-1 {
+		closers[i], closers[j] = closers[j], closers[i]
+	}
+	return dst
+}
 
-		switch ctx.Kind {
-		case KindObjectKey:
-			// object key must be string, therefore, this if could be removed
-			if _, ok := m.filterKeySet[string(ctx.Value)]; ok {
-				// skip this key
-				// m.skipColon = true
-				expectStackSize = ctx.StackSize
-				return nil
-			}
+func (s *modifyState) modify(ctx HandlerContext) {
+	m := s.m
 
-		case KindOther:
-			if skipComma {
-				skipComma = false
-				if ctx.Token == SepComma {
-					// skip this comma
-					return nil
-				}
+	// replace value ------- begin -------
+	if s.replacing != replaceNone && (s.replaceSize >= 0 || ctx.Token != SepColon) {
+		s.replace(ctx)
+		return
+	}
+	// replace value ------- end -------
+
+	// filter keys ------- begin -------
+	if s.skipPathLen > 0 {
+		if len(ctx.Path) >= s.skipPathLen {
+			// skip all colon, comma and values of this key or element
+			return
+		}
+		s.skipPathLen = 0
+		if ctx.Token == SepComma {
+			// skip this comma
+			return
+		}
+		if n := len(s.dst); n > 0 && s.dst[n-1] == ',' {
+			// the filtered one is the last, remove the comma before it
+			s.dst = s.dst[:n-1]
+		}
+	}
+
+	if s.dropped > 0 && ctx.Token == EndArray && len(ctx.Path) == s.droppedPathLen {
+		if m.arrayMarker != nil {
+			n := m.arrayMarker.size(s.dropped) + 3
+			s.reserve(n+len(ctx.Value), ctx.Offset+int64(len(ctx.Value)))
+			if s.dst[len(s.dst)-1] != '[' {
+				s.dst = append(s.dst, ',')
 			}
+			s.dst = append(s.dst, '"')
+			s.dst = m.arrayMarker.appendTo(s.dst, s.dropped)
+			s.dst = append(s.dst, '"')
+		}
+		s.dropped = 0
+	}
+
+	var key, original []byte
+	if m.members {
+		key, original = s.memberKey(ctx)
+		if m.isFiltered(ctx, key, original) {
+			s.skipPathLen = len(ctx.Path)
+			return
+		}
+		if m.arrayLimit > 0 && s.limitArray(ctx) {
+			return
+		}
+		if m.allow && s.allowList(ctx, key, original) {
+			return
 		}
 		// filter keys ------- end -------
 
-		// modify value ------- begin -------
-		needModify := false
-		if m.limit > 0 {
-			switch ctx.Kind {
-			case KindObjectValue,
-				KindArrayValue:
-				if ctx.Token == String && utf8.RuneCount(ctx.Value) > m.limit+2 {
-					needModify = true
-				}
+		if kind := m.replacement(ctx, key, original); kind != replaceNone {
+			s.startReplace(kind, len(ctx.Path))
+			if ctx.Kind != KindObjectKey {
+				s.replace(ctx)
+				return
 			}
 		}
-		if needModify {
-			dst = append(dst, '"')
-			count := 0
-			slashCount := 0
-			for i := 1; ; {
-				r, size := utf8.DecodeRune(ctx.Value[i:])
-				if r == utf8.RuneError {
-					return errors.New("invalid utf8")
-				}
-				if r == '\\' {
-					slashCount++
-				} else {
-					slashCount = 0
+	}
+
+	// modify value ------- begin -------
+	value := ctx.Value
+	end, more := 0, 0 // end of the kept part of the value, if truncated
+	noSuffix := false // the suffix doesn't fit in the byte limit
+	if ctx.Token == String && (ctx.Kind == KindObjectValue || ctx.Kind == KindArrayValue) {
+		if len(m.detectors) > 0 {
+			value = s.maskDetected(value)
+		}
+		if limit := m.fieldLimit(ctx, key); limit > 0 {
+			if count := unquotedLen(value); count > limit {
+				end = 1
+				for i := 0; i < limit; i++ {
+					end += escapedCharSize(value[end:])
 				}
-				dst = append(dst, ctx.Value[i:i+size]...)
-				i += size
-				count++
-				if count >= m.limit {
-					break
+				more = count - limit
+			}
+		}
+		if m.byteLimit > 0 && len(value)-2 > m.byteLimit {
+			// the suffix counts in the limit, sized by the most cut bytes,
+			// it's omitted if it doesn't fit
+			budget := m.byteLimit - m.suffix.size(len(value)-2)
+			withSuffix := budget >= 0
+			if !withSuffix {
+				budget = m.byteLimit
+			}
+			if e := escapedPrefixLen(value, budget); end == 0 || e < end {
+				end = e
+				more = len(value) - 1 - end // cut bytes
+				noSuffix = !withSuffix
+			}
+		}
+	}
+	if end > 0 {
+		suffix := m.suffix
+		if noSuffix {
+			suffix = placeholder{}
+		}
+		s.reserve(end+suffix.size(more)+1, ctx.Offset+int64(len(ctx.Value)))
+		s.dst = append(s.dst, value[:end]...)
+		s.dst = suffix.appendTo(s.dst, more)
+		s.dst = append(s.dst, '"')
+	} else {
+		s.reserve(len(value), ctx.Offset+int64(len(ctx.Value)))
+		s.dst = append(s.dst, value...)
+	}
+	s.written = ctx.Token
+	// modify value ------- end -------
+}
+
+// limitArray applies WithArrayLengthLimit, it returns true if the element
+// starting at this token is dropped. The elements removed by the other
+// options don't count in the limit.
+func (s *modifyState) limitArray(ctx HandlerContext) bool {
+	n := len(ctx.Path)
+	if isElementStart(ctx) {
+		if s.elements[n-1] >= s.m.arrayLimit {
+			s.dropped++
+			s.droppedPathLen = n - 1
+			s.skipPathLen = n
+			return true
+		}
+		s.elements[n-1]++
+	}
+	if ctx.Token == BeginArray {
+		for len(s.elements) <= n {
+			s.elements = append(s.elements, 0)
+		}
+		s.elements[n] = 0
+	}
+	return false
+}
+
+// unkeepElement uncounts the array element whose path length is n, which
+// is removed after counted by limitArray.
+func (s *modifyState) unkeepElement(n int) {
+	if s.m.arrayLimit > 0 {
+		s.elements[n-1]--
+	}
+}
+
+// allowMark is an object member or array element kept tentatively by
+// WithAllowKeys.
+type allowMark struct {
+	pathLen     int
+	dstLen      int  // dst length before the member or element
+	safeLen     int  // safeLen before the member or element
+	bareSafeLen int  // bareSafeLen before the member or element
+	open        bool // the object or array is begun
+	kept        bool // some allowed value is kept in it
+}
+
+// allowList applies WithAllowKeys, it returns true if the token is
+// handled, which is skipped, rolled back or written tentatively.
+func (s *modifyState) allowList(ctx HandlerContext, key, original []byte) bool {
+	m := s.m
+	n := len(ctx.Path)
+	if s.allowedPathLen > 0 {
+		if n >= s.allowedPathLen {
+			return false
+		}
+		s.allowedPathLen = 0
+	}
+
+	if k := len(s.allowMarks); k > 0 && s.allowMarks[k-1].pathLen == n {
+		mark := s.allowMarks[k-1]
+		switch ctx.Token {
+		case SepColon, SepComma:
+			return false
+		case BeginObject, BeginArray:
+			// value of the tentative key
+			s.allowMarks[k-1].open = true
+			s.dst = append(s.dst, ctx.Value...)
+			s.written = ctx.Token
+			return true
+		case EndObject, EndArray:
+			s.allowMarks = s.allowMarks[:k-1]
+			if mark.kept {
+				if k > 1 {
+					s.allowMarks[k-2].kept = true
 				}
+				return false
+			}
+			if m.maxOutput > 0 {
+				// the closer of the rolled back object or array
+				s.closers = s.closers[:len(s.closers)-1]
 			}
-			if slashCount > 0 && slashCount%2 == 1 {
-				dst = dst[:len(dst)-1] // remove the last slash
+			if ctx.Path[n-1].Kind == KindArrayValue {
+				s.unkeepElement(n)
 			}
-			dst = append(dst, '"')
-		} else {
-			dst = append(dst, ctx.Value...)
+			s.rollback(mark)
+			return true
+		default:
+			// scalar value of the tentative key
+			s.allowMarks = s.allowMarks[:k-1]
+			s.rollback(mark)
+			return true
 		}
-		// modify value ------- end -------
+	}
 
-		return nil
+	element := false
+	switch {
+	case ctx.Kind == KindObjectKey:
+	case isElementStart(ctx):
+		element = true
+	default:
+		return false
+	}
+	if (!element && m.allowKeys.match(key, original)) || matchAnyPath(m.allowPaths, ctx.Path) {
+		s.allowedPathLen = n
+		if k := len(s.allowMarks); k > 0 {
+			s.allowMarks[k-1].kept = true
+		}
+		return false
+	}
+
+	mayContain := !m.allowKeys.empty()
+	for _, p := range m.allowPaths {
+		mayContain = mayContain || p.matchPrefix(ctx.Path)
+	}
+	if element && ctx.Token != BeginObject && ctx.Token != BeginArray {
+		mayContain = false
+	}
+	if m.replacement(ctx, key, original) != replaceNone || (m.maxDepth > 0 && n >= m.maxDepth) {
+		// the value would be replaced, the replacement contains no
+		// allowed values
+		mayContain = false
+	}
+	if !mayContain {
+		if element {
+			s.unkeepElement(n)
+		}
+		s.skipPathLen = n
+		return true
+	}
+	s.allowMarks = append(s.allowMarks, allowMark{
+		pathLen:     n,
+		dstLen:      len(s.dst),
+		safeLen:     s.safeLen,
+		bareSafeLen: s.bareSafeLen,
+		open:        element,
 	})
-	err := parser.Parse()
-	if err != nil {
-		return nil, err
+	s.dst = append(s.dst, ctx.Value...)
+	s.written = ctx.Token
+	return true
+}
+
+// rollback removes the member or element of mark from dst, and skips the
+// comma after it.
+func (s *modifyState) rollback(mark allowMark) {
+	s.dst = s.dst[:mark.dstLen]
+	if s.safeLen > mark.dstLen {
+		s.safeLen = mark.safeLen
+	}
+	if s.bareSafeLen > mark.dstLen {
+		s.bareSafeLen = mark.bareSafeLen
+	}
+	s.skipPathLen = mark.pathLen
+}
+
+// startReplace starts replacing the value at path length pathLen, which is
+// the current token or the value of the current key.
+func (s *modifyState) startReplace(kind replaceKind, pathLen int) {
+	s.replacing = kind
+	s.replacePathLen = pathLen
+	s.replaceSize = -1
+}
+
+// replace consumes the tokens of the value being replaced, and writes the
+// replacement at the end of the value.
+func (s *modifyState) replace(ctx HandlerContext) {
+	if s.replaceSize < 0 {
+		switch ctx.Token {
+		case BeginObject, BeginArray:
+			s.replaceSize = len(ctx.Value)
+			if s.replacing == replaceHash {
+				s.resetHash()
+				s.writeHash(ctx.Token, ctx.Value)
+			}
+			return
+		}
+		// scalar value
+		s.writeReplacement(ctx.Token, ctx.Value, ctx.Offset+int64(len(ctx.Value)), len(ctx.Value))
+		return
+	}
+
+	s.replaceSize += len(ctx.Value)
+	if s.replacing == replaceHash {
+		s.writeHash(ctx.Token, ctx.Value)
+	}
+
+	if len(ctx.Path) == s.replacePathLen && (ctx.Token == EndObject || ctx.Token == EndArray) {
+		s.writeReplacement(ctx.Token, nil, ctx.Offset+1, s.replaceSize)
+	}
+}
+
+// writeHash writes the token of the object or array being hashed to the
+// hmac. The strings are re-encoded canonically, so their escapes don't change
+// the hash.
+func (s *modifyState) writeHash(token TokenType, value []byte) {
+	if token != String {
+		s.hash.Write(value)
+		return
+	}
+	s.hashBuf = appendUnquoted(s.hashBuf[:0], value)
+	n := len(s.hashBuf)
+	s.hashBuf = appendQuoted(s.hashBuf, string(s.hashBuf[:n]))
+	s.hash.Write(s.hashBuf[n:])
+}
+
+// writeReplacement writes the replacement of the value of size bytes of
+// compact json, which ends at offset end with the token. value is nil for object and array.
+func (s *modifyState) writeReplacement(token TokenType, value []byte, end int64, size int) {
+	m := s.m
+
+	switch s.replacing {
+	case replaceRedact:
+		n := size
+		if token == String {
+			n = unquotedLen(value)
+		}
+		s.reserve(m.redact.size(n)+2, end)
+		s.dst = append(s.dst, '"')
+		s.dst = m.redact.appendTo(s.dst, n)
+		s.dst = append(s.dst, '"')
+
+	case replaceDepth:
+		replacement := m.depthObject
+		if token == EndArray {
+			replacement = m.depthArray
+		}
+		s.reserve(len(replacement), end)
+		s.dst = append(s.dst, replacement...)
+
+	case replaceHash:
+		if value != nil {
+			s.resetHash()
+			if token == String {
+				s.hashBuf = appendUnquoted(s.hashBuf[:0], value)
+				s.hash.Write(s.hashBuf)
+			} else {
+				s.hash.Write(value)
+			}
+		}
+		s.hashBuf = s.hash.Sum(s.hashBuf[:0])
+		s.reserve(m.hashLen+2, end)
+		s.dst = append(s.dst, '"')
+		s.dst = appendHex(s.dst, s.hashBuf, m.hashLen)
+		s.dst = append(s.dst, '"')
+	}
+
+	s.written = String
+	s.replacing = replaceNone
+	s.replaceSize = -1
+}
+
+type pathLimit struct {
+	expr    string
+	pattern pathPattern
+	limit   int
+}
+
+// fieldLimit returns the length limit of the string value of ctx, key is
+// the key of the value if it's an object value.
+func (m *JsonModifier) fieldLimit(ctx HandlerContext, key []byte) int {
+	for _, l := range m.pathLimits {
+		if l.pattern.match(ctx.Path) {
+			return l.limit
+		}
+	}
+	if len(m.keyLimits) > 0 && key != nil {
+		if limit, ok := m.keyLimits[string(key)]; ok {
+			return limit
+		}
+	}
+	return m.limit
+}
+
+// maskDetected returns the json string value with the substrings found by
+// the detectors masked, or value itself if nothing is found.
+func (s *modifyState) maskDetected(value []byte) []byte {
+	m := s.m
+	s.decoded = appendUnquoted(s.decoded[:0], value)
+	s.matches = s.matches[:0]
+	for _, detect := range m.detectors {
+		s.matches = append(s.matches, detect(s.decoded)...)
+	}
+	if len(s.matches) == 0 {
+		return value
+	}
+	sort.Slice(s.matches, func(i, j int) bool {
+		return s.matches[i][0] < s.matches[j][0]
+	})
+
+	s.masked = append(s.masked[:0], '"')
+	prev := 0
+	for i := 0; i < len(s.matches); {
+		start, end := s.matches[i][0], s.matches[i][1]
+		// merge the overlapped matches
+		for i++; i < len(s.matches) && s.matches[i][0] < end; i++ {
+			if s.matches[i][1] > end {
+				end = s.matches[i][1]
+			}
+		}
+		if start < prev {
+			start = prev
+		}
+		s.masked = appendEscaped(s.masked, string(s.decoded[prev:start]))
+		s.masked = m.mask.appendTo(s.masked, utf8.RuneCount(s.decoded[start:end]))
+		prev = end
+	}
+	s.masked = appendEscaped(s.masked, string(s.decoded[prev:]))
+	return append(s.masked, '"')
+}
+
+func (s *modifyState) resetHash() {
+	if s.hash == nil {
+		s.hash = hmac.New(sha256.New, s.m.hashSecret)
+	}
+	s.hash.Reset()
+}
+
+// appendHex appends the first n hex characters of b to dst.
+func appendHex(dst []byte, b []byte, n int) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < n; i++ {
+		c := b[i/2]
+		if i%2 == 0 {
+			c >>= 4
+		}
+		dst = append(dst, hex[c&0xf])
+	}
+	return dst
+}
+
+// memberKey returns the decoded key of the object key or value, which is
+// lowered if WithKeyCaseInsensitive, and the original decoded key. Both are
+// nil for other tokens.
+func (s *modifyState) memberKey(ctx HandlerContext) ([]byte, []byte) {
+	if ctx.Kind != KindObjectKey && ctx.Kind != KindObjectValue {
+		return nil, nil
+	}
+	original := ctx.Path[len(ctx.Path)-1].Key
+	if !s.m.foldKeys {
+		return original, original
+	}
+	s.keyBuf = appendLower(s.keyBuf[:0], original)
+	return s.keyBuf, original
+}
+
+// replacement returns how the value starting at this token, or the value of
+// the object key, is replaced by WithRedactKeys, WithHashKeys or
+// WithMaxDepth, replaceNone if it's not.
+func (m *JsonModifier) replacement(ctx HandlerContext, key, original []byte) replaceKind {
+	switch {
+	case ctx.Kind == KindObjectKey:
+		if m.redactKeys.match(key, original) {
+			return replaceRedact
+		}
+		if m.isHashed(ctx, key, original) {
+			return replaceHash
+		}
+	case isElementStart(ctx) && m.isHashed(ctx, nil, nil):
+		return replaceHash
+	case ctx.Token == BeginObject || ctx.Token == BeginArray:
+		if m.maxDepth > 0 && len(ctx.Path) >= m.maxDepth {
+			return replaceDepth
+		}
+	}
+	return replaceNone
+}
+
+// isHashed reports whether the value of the object key or the array element
+// starting at this token should be hashed.
+func (m *JsonModifier) isHashed(ctx HandlerContext, key, original []byte) bool {
+	if ctx.Kind == KindObjectKey && m.hashKeys.match(key, original) {
+		return true
+	}
+	return matchAnyPath(m.hashPaths, ctx.Path)
+}
+
+// isFiltered reports whether the object member or array element starting
+// at this token should be removed.
+func (m *JsonModifier) isFiltered(ctx HandlerContext, key, original []byte) bool {
+	switch {
+	case ctx.Kind == KindObjectKey:
+		if m.filterKeys.match(key, original) {
+			return true
+		}
+	case isElementStart(ctx):
+	default:
+		return false
+	}
+	return matchAnyPath(m.filterPaths, ctx.Path)
+}
+
+// isElementStart reports whether the token is the start of an array element.
+func isElementStart(ctx HandlerContext) bool {
+	switch ctx.Token {
+	case BeginObject, BeginArray:
+		n := len(ctx.Path)
+		return n > 0 && ctx.Path[n-1].Kind == KindArrayValue
 	}
-	return dst, nil
+	return ctx.Kind == KindArrayValue
 }
 
 func ModifyJson(data []byte, opts ...JsonModifierOption) ([]byte, error) {
@@ -1,92 +1,257 @@
 package jsontools
 
 type JsonNullFilter struct {
-	inplace bool
+	inplace    bool
+	arrayNulls bool          // filter null array elements too
+	empty      EmptyKind     // empty object values to filter besides null
+	exclude    []pathPattern // values at or below are never filtered
+	err        error
 }
 
+// EmptyKind is a set of the kinds of values counted as empty by
+// JsonNullFilter, like the omitempty of encoding/json.
+type EmptyKind uint8
+
+const (
+	EmptyString EmptyKind = 1 << iota // ""
+	EmptyNumber                       // 0, 0.0, -0, 0e10
+	EmptyFalse                        // false
+	EmptyArray                        // []
+	EmptyObject                       // {}
+
+	// EmptyAll are all the empty values dropped by omitempty.
+	EmptyAll = EmptyString | EmptyNumber | EmptyFalse | EmptyArray | EmptyObject
+)
+
+// JsonNullFilterOption configures the JsonNullFilter created by
+// NewJsonNullFilterWithOptions.
+type JsonNullFilterOption func(*JsonNullFilter)
+
+// WithNullFilterInplace filters the input in place, the input is
+// overwritten by the result.
+func WithNullFilterInplace(inplace bool) JsonNullFilterOption {
+	return func(f *JsonNullFilter) {
+		f.inplace = inplace
+	}
+}
+
+// WithNullFilterArrayNulls filters null array elements too, eg: [1,null,2]
+// becomes [1,2]. Only null object values are filtered by default.
+func WithNullFilterArrayNulls(filter bool) JsonNullFilterOption {
+	return func(f *JsonNullFilter) {
+		f.arrayNulls = filter
+	}
+}
+
+// WithNullFilterEmpty filters the empty object values of kinds besides null,
+// eg: EmptyString|EmptyArray. The objects and arrays which become empty
+// after their members are filtered are empty too, so EmptyArray|EmptyObject
+// prunes the empty containers recursively, while the array elements are
+// never counted as empty, as omitempty.
+func WithNullFilterEmpty(kinds EmptyKind) JsonNullFilterOption {
+	return func(f *JsonNullFilter) {
+		f.empty = kinds
+	}
+}
+
+// WithNullFilterExcludePaths keeps the values at or below paths as they
+// are, in the syntax of WithFilterPaths, eg: "$.patch" keeps the nulls of
+// a JSON Merge Patch, which delete the members.
+func WithNullFilterExcludePaths(paths ...string) JsonNullFilterOption {
+	return func(f *JsonNullFilter) {
+		patterns, err := compilePaths(paths)
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.exclude = append(f.exclude, patterns...)
+	}
+}
+
+// NewJsonNullFilter is kept for compatibility, it's the same as
+// NewJsonNullFilterWithOptions(WithNullFilterInplace(inplace)).
 func NewJsonNullFilter(inplace bool) *JsonNullFilter {
 	return &JsonNullFilter{inplace: inplace}
 }
 
+// NewJsonNullFilterWithOptions creates a JsonNullFilter, which filters only
+// null object values without options.
+func NewJsonNullFilterWithOptions(opts ...JsonNullFilterOption) *JsonNullFilter {
+	f := &JsonNullFilter{}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
 func (f *JsonNullFilter) Filter(data []byte) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
 	if len(data) == 0 {
 		return data, nil
 	}
-
-	var dst []byte
 	if f.inplace {
-		dst = data[:0]
-	} else {
-		dst = make([]byte, 0, len(data))
+		return f.appendFiltered(data[:0], data)
 	}
+	return f.appendFiltered(make([]byte, 0, len(data)), data)
+}
+
+// AppendFiltered appends the filtered json of src to dst and returns the
+// extended buffer, like strconv.AppendInt, so that dst could be reused.
+// src is never modified, even if the filter is inplace. On error, dst is
+// returned as it is.
+func (f *JsonNullFilter) AppendFiltered(dst, src []byte) ([]byte, error) {
+	if f.err != nil {
+		return dst, f.err
+	}
+	if len(src) == 0 {
+		return dst, nil
+	}
+	result, err := f.appendFiltered(dst, src)
+	if err != nil {
+		return dst, err
+	}
+	return result, nil
+}
+
+// filterFrame is an object or array being filtered.
+type filterFrame struct {
+	start    int  // dst length before the member or element, -1 for the root
+	kept     int  // number of the members or elements kept
+	array    bool // it's an array
+	excluded bool // it's at or below the excluded paths
+}
+
+func (f *JsonNullFilter) appendFiltered(dst, data []byte) ([]byte, error) {
+	// the commas are written before the kept members and elements, instead
+	// of the original ones, so a filtered member leaves no comma behind.
+	// dst never exceeds the input read, which is required by inplace.
+	frames := make([]filterFrame, 0, 16)
+	start := -1 // dst length before the current object key
 
-	lastPos := -1
-	skipComma := false
-	commaSkipped := false
 	parser := NewJsonParser(data, func(ctx HandlerContext) error {
+		var frame *filterFrame
+		if len(frames) > 0 {
+			frame = &frames[len(frames)-1]
+		}
 
-		switch ctx.Kind {
-		case KindObjectKey:
-			if commaSkipped {
-				if dst[len(dst)-1] != '{' {
-					// restore the comma for next key
+		switch ctx.Token {
+		case SepComma:
+			return nil
+
+		case SepColon:
+			dst = append(dst, ':')
+			return nil
+
+		case BeginObject, BeginArray:
+			memberStart := start
+			if frame != nil && frame.array {
+				memberStart = len(dst)
+				if frame.kept > 0 {
 					dst = append(dst, ',')
 				}
-				commaSkipped = false
 			}
-		default:
-			commaSkipped = false
+			frames = append(frames, filterFrame{
+				start:    memberStart,
+				array:    ctx.Token == BeginArray,
+				excluded: f.isExcluded(frame, ctx.Path),
+			})
+			dst = append(dst, ctx.Value...)
+			return nil
+
+		case EndObject, EndArray:
+			frames = frames[:len(frames)-1]
+			if len(frames) == 0 {
+				dst = append(dst, ctx.Value...)
+				return nil
+			}
+			parent := &frames[len(frames)-1]
+			if frame.kept == 0 && !parent.array && !frame.excluded {
+				kind := EmptyObject
+				if frame.array {
+					kind = EmptyArray
+				}
+				if f.empty&kind != 0 {
+					dst = dst[:frame.start]
+					return nil
+				}
+			}
+			dst = append(dst, ctx.Value...)
+			parent.kept++
+			return nil
 		}
 
 		switch ctx.Kind {
 		case KindObjectKey:
+			start = len(dst)
+			if frame.kept > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, ctx.Value...)
 
 		case KindObjectValue:
-			if lastPos != -1 {
-				switch ctx.Token {
-				case Null:
-					dst = dst[:lastPos] // remove the key
-					skipComma = true
-					lastPos = -1
-					return nil
-				default:
-					lastPos = -1
-				}
+			if (ctx.Token == Null || f.isEmpty(ctx.Token, ctx.Value)) && !f.isExcluded(frame, ctx.Path) {
+				// remove the key
+				dst = dst[:start]
+				return nil
 			}
+			dst = append(dst, ctx.Value...)
+			frame.kept++
 
 		case KindArrayValue:
-			if lastPos != -1 {
-				lastPos = -1
-			}
-
-		case KindOther:
-			switch ctx.Token {
-			case SepComma:
-				lastPos = len(dst)
+			if f.arrayNulls && ctx.Token == Null && !f.isExcluded(frame, ctx.Path) {
+				return nil
 			}
-
-			if skipComma {
-				skipComma = false
-				if ctx.Token == SepComma {
-					commaSkipped = true
-					// skip this comma
-					return nil
-				}
+			if frame.kept > 0 {
+				dst = append(dst, ',')
 			}
-		}
-
-		dst = append(dst, ctx.Value...)
-
-		switch ctx.Token {
-		case BeginObject:
-			lastPos = len(dst)
+			dst = append(dst, ctx.Value...)
+			frame.kept++
 		}
 		return nil
 	})
 
+	parser.SetPathKeys(len(f.exclude) > 0)
 	err := parser.Parse()
 	if err != nil {
 		return nil, err
 	}
 	return dst, nil
 }
+
+// isExcluded reports whether the value at path, in the parent frame, is at
+// or below the excluded paths.
+func (f *JsonNullFilter) isExcluded(parent *filterFrame, path Path) bool {
+	if parent != nil && parent.excluded {
+		return true
+	}
+	return matchAnyPath(f.exclude, path)
+}
+
+// isEmpty reports whether the scalar value is empty by f.empty.
+func (f *JsonNullFilter) isEmpty(token TokenType, value []byte) bool {
+	switch token {
+	case String:
+		return f.empty&EmptyString != 0 && len(value) == 2
+	case False:
+		return f.empty&EmptyFalse != 0
+	case Number, Float:
+		return f.empty&EmptyNumber != 0 && isZeroNumber(value)
+	}
+	return false
+}
+
+// isZeroNumber reports whether the json number is zero.
+func isZeroNumber(value []byte) bool {
+	for _, c := range value {
+		switch c {
+		case '-', '0', '.':
+		case 'e', 'E':
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -44,5 +44,136 @@ func TestJsonNullFilter(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, c.expected, string(dst), "case %d: dst:%s src:%s", i, dst, input)
 		t.Logf("inplace case %d: dst:%s src:%s", i, dst, input)
+
+		// append, src is kept even if inplace
+		input = []byte(c.input)
+		buf := append(make([]byte, 0, 64), "prefix:"...)
+		dst, err = jsontools.NewJsonNullFilter(true).AppendFiltered(buf, input)
+		require.NoError(t, err)
+		require.Equal(t, "prefix:"+c.expected, string(dst), "case %d: %s", i, c.input)
+		require.Equal(t, c.input, string(input))
+	}
+
+	buf := []byte("prefix:")
+	dst, err := jsontools.NewJsonNullFilter(false).AppendFiltered(buf, []byte(`{"a":`))
+	require.Error(t, err)
+	require.Equal(t, "prefix:", string(dst))
+}
+
+func TestJsonNullFilterArrayNulls(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{`[null]`, `[]`},
+		{`[null,null]`, `[]`},
+		{`[null,1,2]`, `[1,2]`},
+		{`[1, null ,2]`, `[1,2]`},
+		{`[1,2,null]`, `[1,2]`},
+		{`[1,null,null,2,null]`, `[1,2]`},
+		{`[null,[null,1],[null],{"a":null,"b":[2,null]},null]`, `[[1],[],{"b":[2]}]`},
+		{`{"a":[null,"null"],"b":null,"c":[null]}`, `{"a":["null"],"c":[]}`},
+		{`{"a":null,"b":[1,null]}`, `{"b":[1]}`},
+		{`{"a":[1,null],"b":null,"c":1}`, `{"a":[1],"c":1}`},
+	}
+	for i, c := range cases {
+		filter := jsontools.NewJsonNullFilterWithOptions(jsontools.WithNullFilterArrayNulls(true))
+		dst, err := filter.Filter([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(dst), "case %d: %s", i, c.input)
+
+		filter = jsontools.NewJsonNullFilterWithOptions(jsontools.WithNullFilterArrayNulls(true), jsontools.WithNullFilterInplace(true))
+		dst, err = filter.Filter([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(dst), "case %d: %s", i, c.input)
+	}
+
+	// array elements are kept by default
+	dst, err := jsontools.NewJsonNullFilter(false).Filter([]byte(`[1,null,2]`))
+	require.NoError(t, err)
+	require.Equal(t, `[1,null,2]`, string(dst))
+}
+
+func TestJsonNullFilterEmpty(t *testing.T) {
+	cases := []struct {
+		kinds    jsontools.EmptyKind
+		input    string
+		expected string
+	}{
+		{jsontools.EmptyAll, `{"a":"","b":0,"c":false,"d":[],"e":{},"f":null}`, `{}`},
+		{jsontools.EmptyAll, `{"a":"x","b":1,"c":true,"d":[0],"e":{"a":1}}`, `{"a":"x","b":1,"c":true,"d":[0],"e":{"a":1}}`},
+		{jsontools.EmptyAll, `{"a":-0,"b":0.0,"c":0e10,"d":0.1,"e":1e0}`, `{"d":0.1,"e":1e0}`},
+		{jsontools.EmptyAll, `{"a":{"b":{"c":null,"d":""}},"e":1}`, `{"e":1}`},
+		{jsontools.EmptyAll, `{"a":[{"b":""},[],""],"c":{ }}`, `{"a":[{},[],""]}`},
+		{jsontools.EmptyAll, `[{},{"a":""},[]]`, `[{},{},[]]`},
+		{jsontools.EmptyAll, `{}`, `{}`},
+		{jsontools.EmptyString, `{"a":"","b":0,"c":false,"d":[],"e":{"f":""}}`, `{"b":0,"c":false,"d":[],"e":{}}`},
+		{jsontools.EmptyNumber | jsontools.EmptyFalse, `{"a":"","b":0,"c":false}`, `{"a":""}`},
+		{jsontools.EmptyArray, `{"a":[],"b":{},"c":[ ]}`, `{"b":{}}`},
+		{jsontools.EmptyObject, `{"a":[],"b":{"c":null}, "d":1}`, `{"a":[],"d":1}`},
 	}
+	for i, c := range cases {
+		filter := jsontools.NewJsonNullFilterWithOptions(jsontools.WithNullFilterEmpty(c.kinds))
+		dst, err := filter.Filter([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(dst), "case %d: %s", i, c.input)
+
+		filter = jsontools.NewJsonNullFilterWithOptions(jsontools.WithNullFilterEmpty(c.kinds), jsontools.WithNullFilterInplace(true))
+		dst, err = filter.Filter([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(dst), "case %d: %s", i, c.input)
+	}
+
+	// with array nulls, the arrays of nulls become empty
+	filter := jsontools.NewJsonNullFilterWithOptions(jsontools.WithNullFilterArrayNulls(true), jsontools.WithNullFilterEmpty(jsontools.EmptyArray))
+	dst, err := filter.Filter([]byte(`{"a":[null,null],"b":[null,1]}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"b":[1]}`, string(dst))
+}
+
+func TestJsonNullFilterOptions(t *testing.T) {
+	cases := []struct {
+		opts     []jsontools.JsonNullFilterOption
+		input    string
+		expected string
+	}{
+		{nil, `{"a":null,"b":[null]}`, `{"b":[null]}`},
+		{
+			[]jsontools.JsonNullFilterOption{jsontools.WithNullFilterArrayNulls(true)},
+			`{"a":null,"b":[null]}`, `{"b":[]}`,
+		},
+		{
+			[]jsontools.JsonNullFilterOption{jsontools.WithNullFilterArrayNulls(true), jsontools.WithNullFilterEmpty(jsontools.EmptyArray | jsontools.EmptyObject)},
+			`{"a":null,"b":[null],"c":{"d":{"e":null}},"f":""}`, `{"f":""}`,
+		},
+		{
+			// the nulls of merge patch are kept
+			[]jsontools.JsonNullFilterOption{jsontools.WithNullFilterExcludePaths("$.patch")},
+			`{"id":null,"patch":{"a":null,"b":{"c":null}}}`, `{"patch":{"a":null,"b":{"c":null}}}`,
+		},
+		{
+			[]jsontools.JsonNullFilterOption{jsontools.WithNullFilterExcludePaths("$..keep", "$.items[1]"), jsontools.WithNullFilterArrayNulls(true), jsontools.WithNullFilterEmpty(jsontools.EmptyAll)},
+			`{"a":{"keep":null,"b":null},"c":{"keep":{}},"items":[null,null,{}],"d":{"e":""}}`,
+			`{"a":{"keep":null},"c":{"keep":{}},"items":[null,{}]}`,
+		},
+	}
+	for i, c := range cases {
+		dst, err := jsontools.NewJsonNullFilterWithOptions(c.opts...).Filter([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(dst), "case %d: %s", i, c.input)
+
+		// inplace
+		opts := append(c.opts, jsontools.WithNullFilterInplace(true))
+		input := []byte(c.input)
+		dst, err = jsontools.NewJsonNullFilterWithOptions(opts...).Filter(input)
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(dst), "case %d: %s", i, c.input)
+	}
+
+	filter := jsontools.NewJsonNullFilterWithOptions(jsontools.WithNullFilterExcludePaths("a"))
+	_, err := filter.Filter([]byte(`{}`))
+	require.ErrorIs(t, err, jsontools.ErrInvalidPath)
+	dst, err := filter.AppendFiltered([]byte("prefix:"), []byte(`{}`))
+	require.ErrorIs(t, err, jsontools.ErrInvalidPath)
+	require.Equal(t, "prefix:", string(dst))
 }
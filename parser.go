@@ -1,8 +1,8 @@
 package jsontools
 
 import (
-	"errors"
 	"fmt"
+	"io"
 )
 
 type Kind byte
@@ -27,9 +27,17 @@ func (s Kind) String() string {
 	}
 }
 
+// DefaultMaxDepth is the default max nesting depth of objects and arrays.
+const DefaultMaxDepth = 10000
+
 type jsonParser struct {
 	jsonTokenizer
 	stack       []Kind
+	path        Path
+	pathKeys    bool   // decode the keys of path
+	keys        []byte // stack of the decoded keys of path
+	depth       int
+	maxDepth    int
 	userHandler jsonParserHandler
 	err         error
 }
@@ -41,10 +49,48 @@ func NewJsonParser(data []byte, handler jsonParserHandler) *jsonParser {
 			current: Init,
 		},
 		stack:       make([]Kind, 0, 32),
+		path:        make(Path, 0, 16),
+		maxDepth:    DefaultMaxDepth,
 		userHandler: handler,
 	}
 }
 
+// NewJsonReaderParser returns a parser which reads json from r. Unlike
+// NewJsonParser, HandlerContext.Value is only valid inside the handler,
+// since the underlying buffer is reused.
+func NewJsonReaderParser(r io.Reader, handler jsonParserHandler) *jsonParser {
+	return &jsonParser{
+		jsonTokenizer: *NewJsonReaderTokenizer(r),
+		stack:         make([]Kind, 0, 32),
+		path:          make(Path, 0, 16),
+		maxDepth:      DefaultMaxDepth,
+		userHandler:   handler,
+	}
+}
+
+// SetPathKeys sets whether the keys of HandlerContext.Path are decoded.
+// It's disabled by default since decoding every key has a cost, and Key of
+// the path elements is nil then, while the kinds and indices are always
+// tracked.
+func (t *jsonParser) SetPathKeys(decode bool) {
+	t.pathKeys = decode
+}
+
+// SetMaxDepth sets the max nesting depth of objects and arrays, deeper
+// input is reported as ErrDepthExceeded.
+func (t *jsonParser) SetMaxDepth(depth int) {
+	t.maxDepth = depth
+}
+
+// enter is called on '{' or '['.
+func (t *jsonParser) enter() error {
+	t.depth++
+	if t.depth > t.maxDepth {
+		return t.tokenError(ErrDepthExceeded, fmt.Sprintf("exceeded max depth %d", t.maxDepth))
+	}
+	return nil
+}
+
 type parseFlags int
 
 const (
@@ -68,6 +114,11 @@ type HandlerContext struct {
 	Kind      Kind
 	Value     []byte
 	StackSize int
+	Offset    int64 // byte offset of the token in the input
+	// Path is the location of the value which the token belongs to, keys
+	// and ':' belong to the member value, ',' belongs to the enclosing
+	// object or array.
+	Path Path
 }
 
 type jsonParserHandler func(ctx HandlerContext) error
@@ -79,6 +130,9 @@ func (t *jsonParser) push(kind Kind) {
 func (t *jsonParser) pop() Kind {
 	kind := t.stack[len(t.stack)-1]
 	t.stack = t.stack[:len(t.stack)-1]
+	if kind == KindObjectKey {
+		t.popPath()
+	}
 	return kind
 }
 
@@ -91,12 +145,22 @@ func (t *jsonParser) isEmpty() bool {
 }
 
 func (t *jsonParser) handler(token TokenType, kind Kind, value []byte) {
-	t.err = t.userHandler(HandlerContext{
+	err := t.userHandler(HandlerContext{
 		Token:     token,
 		Kind:      kind,
 		Value:     value,
 		StackSize: len(t.stack),
+		Offset:    t.offset,
+		Path:      t.path,
 	})
+	if err != nil {
+		t.err = &HandlerError{Offset: t.offset, Err: err}
+	}
+}
+
+// tokenError creates a SyntaxError at the last token.
+func (t *jsonParser) tokenError(err error, msg string) error {
+	return t.syntaxError(int(t.offset-t.base), err, msg)
 }
 
 func (t *jsonParser) Parse() error {
@@ -114,7 +178,10 @@ func (t *jsonParser) Parse() error {
 		switch token {
 		case BeginObject:
 			if !flags.has(flagBeginObject) {
-				return errors.New("invalid '{'")
+				return t.tokenError(ErrInvalidToken, "invalid '{'")
+			}
+			if err := t.enter(); err != nil {
+				return err
 			}
 			t.push(KindObjectValue)
 			t.handler(token, KindOther, value)
@@ -125,6 +192,7 @@ func (t *jsonParser) Parse() error {
 			if flags.has(flagObjectKey) {
 				// current is key, expect ':'
 				t.push(KindObjectKey)
+				t.pushPath(KindObjectKey, value)
 				t.handler(token, KindObjectKey, value)
 				flags = flagColon
 				continue
@@ -142,7 +210,7 @@ func (t *jsonParser) Parse() error {
 				flags = flagComma | flagEndArray
 				continue
 			}
-			return fmt.Errorf("invalid string '%s'", string(value))
+			return t.tokenError(ErrInvalidToken, fmt.Sprintf("invalid string '%s'", string(value)))
 
 		case Number:
 			if flags.has(flagObjectValue) {
@@ -158,7 +226,7 @@ func (t *jsonParser) Parse() error {
 				flags = flagComma | flagEndArray
 				continue
 			}
-			return fmt.Errorf("invalid int '%s'", string(value))
+			return t.tokenError(ErrInvalidToken, fmt.Sprintf("invalid int '%s'", string(value)))
 
 		case Float:
 			if flags.has(flagObjectValue) {
@@ -174,7 +242,7 @@ func (t *jsonParser) Parse() error {
 				flags = flagComma | flagEndArray
 				continue
 			}
-			return fmt.Errorf("invalid float '%s'", string(value))
+			return t.tokenError(ErrInvalidToken, fmt.Sprintf("invalid float '%s'", string(value)))
 
 		case True:
 			if flags.has(flagObjectValue) {
@@ -190,7 +258,7 @@ func (t *jsonParser) Parse() error {
 				flags = flagComma | flagEndArray
 				continue
 			}
-			return fmt.Errorf("invalid bool '%s'", string(value))
+			return t.tokenError(ErrInvalidToken, fmt.Sprintf("invalid bool '%s'", string(value)))
 
 		case False:
 			if flags.has(flagObjectValue) {
@@ -206,7 +274,7 @@ func (t *jsonParser) Parse() error {
 				flags = flagComma | flagEndArray
 				continue
 			}
-			return fmt.Errorf("invalid bool '%s'", string(value))
+			return t.tokenError(ErrInvalidToken, fmt.Sprintf("invalid bool '%s'", string(value)))
 
 		case Null:
 			if flags.has(flagObjectValue) {
@@ -222,11 +290,11 @@ func (t *jsonParser) Parse() error {
 				flags = flagComma | flagEndArray
 				continue
 			}
-			return fmt.Errorf("invalid null '%s'", string(value))
+			return t.tokenError(ErrInvalidToken, fmt.Sprintf("invalid null '%s'", string(value)))
 
 		case SepComma:
 			if !flags.has(flagComma) {
-				return errors.New("invalid ','")
+				return t.tokenError(ErrInvalidToken, "invalid ','")
 			}
 			if flags.has(flagEndObject) {
 				// current is ',' in object, expect key
@@ -236,32 +304,43 @@ func (t *jsonParser) Parse() error {
 			}
 			if flags.has(flagEndArray) {
 				// current is ',' in array, expect value or object
+				path := t.path
+				t.path = path[:len(path)-1]
 				t.handler(token, KindOther, value)
+				t.path = path
+				t.path[len(path)-1].Index++
 				flags = flagArrayValue | flagBeginArray | flagBeginObject
 				continue
 			}
 
 		case SepColon:
 			if !flags.has(flagColon) {
-				return errors.New("invalid ':'")
+				return t.tokenError(ErrInvalidToken, "invalid ':'")
 			}
 			// current is ':', expect value, object or array
 			t.handler(token, KindOther, value)
 			flags = flagObjectValue | flagBeginObject | flagBeginArray
 
 		case BeginArray:
-			if flags.has(flagBeginArray) {
-				// current is '[', expect ']' or value or object
-				t.push(KindArrayValue)
-				t.handler(token, KindOther, value)
-				flags = flagArrayValue | flagBeginArray | flagEndArray | flagBeginObject
+			if !flags.has(flagBeginArray) {
+				return t.tokenError(ErrInvalidToken, "invalid '['")
+			}
+			if err := t.enter(); err != nil {
+				return err
 			}
+			// current is '[', expect ']' or value or object
+			t.push(KindArrayValue)
+			t.handler(token, KindOther, value)
+			t.pushPath(KindArrayValue, nil)
+			flags = flagArrayValue | flagBeginArray | flagEndArray | flagBeginObject
 
 		case EndArray:
 			if !flags.has(flagEndArray) {
-				return errors.New("invalid ']'")
+				return t.tokenError(ErrInvalidToken, "invalid ']'")
 			}
+			t.depth--
 			t.pop()
+			t.popPath()
 			if t.isEmpty() {
 				// current is ']', but if stack is empty, which means array is top level.
 				// expect another object/array.
@@ -292,12 +371,13 @@ func (t *jsonParser) Parse() error {
 				continue
 			}
 
-			return errors.New("missing ']'")
+			return t.tokenError(ErrInvalidToken, "missing ']'")
 
 		case EndObject:
 			if !flags.has(flagEndObject) {
-				return errors.New("invalid '}'")
+				return t.tokenError(ErrInvalidToken, "invalid '}'")
 			}
+			t.depth--
 			t.pop()
 			if t.isEmpty() {
 				// current is '}', but if stack is empty, which means object is top level.
@@ -329,18 +409,18 @@ func (t *jsonParser) Parse() error {
 				continue
 			}
 
-			return errors.New("invalid '}'")
+			return t.tokenError(ErrInvalidToken, "invalid '}'")
 
 		case EndJson:
 			if t.isEmpty() {
-				return errors.New("invalid EOF1")
+				return t.tokenError(ErrUnexpectedEOF, "invalid EOF1")
 			}
 
 			t.pop()
-			if t.isEmpty() {
+			if t.isEmpty() && t.depth == 0 {
 				return nil
 			}
-			return errors.New("invalid EOF2")
+			return t.tokenError(ErrUnexpectedEOF, "invalid EOF2")
 		}
 	}
 }
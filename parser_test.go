@@ -3,7 +3,9 @@ package jsontools_test
 import (
 	"bytes"
 	"errors"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/WqyJh/jsontools"
 	"github.com/stretchr/testify/require"
@@ -28,7 +30,11 @@ func TestParser(t *testing.T) {
 		called++
 		return err
 	})
-	require.ErrorIs(t, err, parser.Parse())
+	parseErr := parser.Parse()
+	require.ErrorIs(t, parseErr, err)
+	var handlerErr *jsontools.HandlerError
+	require.ErrorAs(t, parseErr, &handlerErr)
+	require.Equal(t, int64(0), handlerErr.Offset)
 	require.Equal(t, 1, called)
 
 	// with whitespace prefix/suffix
@@ -57,62 +63,138 @@ func TestParser2(t *testing.T) {
 	require.Equal(t, expected, buf.String())
 }
 
+func TestReaderParser(t *testing.T) {
+	var buf bytes.Buffer
+	var paths []string
+	parser := jsontools.NewJsonReaderParser(iotest.OneByteReader(strings.NewReader(expected1)), func(ctx jsontools.HandlerContext) error {
+		buf.Write(ctx.Value)
+		paths = append(paths, ctx.Path.String())
+		return nil
+	})
+	parser.SetPathKeys(true)
+	require.NoError(t, parser.Parse())
+	require.JSONEq(t, expected1, buf.String())
+
+	var expectedPaths []string
+	parser = jsontools.NewJsonParser([]byte(expected1), func(ctx jsontools.HandlerContext) error {
+		expectedPaths = append(expectedPaths, ctx.Path.String())
+		return nil
+	})
+	parser.SetPathKeys(true)
+	require.NoError(t, parser.Parse())
+	require.Equal(t, expectedPaths, paths)
+}
+
+func TestParserPath(t *testing.T) {
+	src := `{"a":1,"items":[{"password":"x","tags":["t1",[2,3]]},[]],"a b":{"it's":null},"\u0061":{}}`
+	expected := []string{
+		`{ $`,
+		`"a" $.a`, `: $.a`, `1 $.a`, `, $`,
+		`"items" $.items`, `: $.items`, `[ $.items`,
+		`{ $.items[0]`, `"password" $.items[0].password`, `: $.items[0].password`, `"x" $.items[0].password`, `, $.items[0]`,
+		`"tags" $.items[0].tags`, `: $.items[0].tags`, `[ $.items[0].tags`, `"t1" $.items[0].tags[0]`, `, $.items[0].tags`,
+		`[ $.items[0].tags[1]`, `2 $.items[0].tags[1][0]`, `, $.items[0].tags[1]`, `3 $.items[0].tags[1][1]`, `] $.items[0].tags[1]`,
+		`] $.items[0].tags`, `} $.items[0]`, `, $.items`, `[ $.items[1]`, `] $.items[1]`, `] $.items`, `, $`,
+		`"a b" $['a b']`, `: $['a b']`, `{ $['a b']`, `"it's" $['a b']['it\'s']`, `: $['a b']['it\'s']`, `null $['a b']['it\'s']`, `} $['a b']`, `, $`,
+		`"\u0061" $.a`, `: $.a`, `{ $.a`, `} $.a`,
+		`} $`,
+	}
+
+	var paths []string
+	parser := jsontools.NewJsonParser([]byte(src), func(ctx jsontools.HandlerContext) error {
+		paths = append(paths, string(ctx.Value)+" "+ctx.Path.String())
+		return nil
+	})
+	parser.SetPathKeys(true)
+	require.NoError(t, parser.Parse())
+	require.Equal(t, expected, paths)
+
+	// the keys are not decoded by default
+	var path jsontools.Path
+	parser = jsontools.NewJsonParser([]byte(`{"a":[{"b":1}]}`), func(ctx jsontools.HandlerContext) error {
+		if ctx.Token == jsontools.Number {
+			path = ctx.Path.Clone()
+		}
+		return nil
+	})
+	require.NoError(t, parser.Parse())
+	require.Equal(t, jsontools.Path{
+		{Kind: jsontools.KindObjectKey},
+		{Kind: jsontools.KindArrayValue, Index: 0},
+		{Kind: jsontools.KindObjectKey},
+	}, path)
+}
+
 func TestParserError(t *testing.T) {
 	cases := []struct {
 		src      string
 		expected string
+		offset   int64
 	}{
 		{
 			src:      ` `,
 			expected: "invalid EOF1",
+			offset:   1,
 		},
 		{
 			src:      `{"hello"{`,
 			expected: `invalid '{'`,
+			offset:   8,
 		},
 		{
 			src:      `"hello"`,
 			expected: `invalid string '"hello"'`,
+			offset:   0,
 		},
 		{
 			src:      `12345`,
 			expected: `invalid int '12345'`,
+			offset:   0,
 		},
 		{
 			src:      `{"key":"value" 1.23}`,
 			expected: "invalid float '1.23'",
+			offset:   15,
 		},
 		{
 			src:      `{"key":"value", true}`,
 			expected: "invalid bool 'true'",
+			offset:   16,
 		},
 		{
 			src:      `[]false`,
 			expected: "invalid bool 'false'",
+			offset:   2,
 		},
 		{
 			src:      `{"key":"value"}null}`,
 			expected: "invalid null 'null'",
+			offset:   15,
 		},
 		{
 			src:      `{"key":"value"},null}`,
 			expected: "invalid ','",
+			offset:   15,
 		},
 		{
 			src:      `{"key"::"value"}}`,
 			expected: "invalid ':'",
+			offset:   7,
 		},
 		{
 			src:      `{"key":"value"]}`,
 			expected: "invalid ']'",
+			offset:   14,
 		},
 		{
 			src:      `[1,2}`,
 			expected: "invalid '}'",
+			offset:   4,
 		},
 		{
 			src:      `{{`,
 			expected: "invalid EOF2",
+			offset:   2,
 		},
 	}
 	for _, c := range cases {
@@ -120,11 +202,78 @@ func TestParserError(t *testing.T) {
 			return nil
 		})
 		err := parser.Parse()
-		require.Error(t, err)
-		require.Equal(t, c.expected, err.Error())
+		var syntaxErr *jsontools.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+		require.Equal(t, c.expected, syntaxErr.Msg)
+		require.Equal(t, c.offset, syntaxErr.Offset, c.src)
 	}
 }
 
+func TestParserErrorIs(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected error
+	}{
+		{``, jsontools.ErrUnexpectedEOF},
+		{`{"key":`, jsontools.ErrUnexpectedEOF},
+		{`[1, 2`, jsontools.ErrUnexpectedEOF},
+		{`{"key":"val`, jsontools.ErrUnexpectedEOF},
+		{`{"key":tr`, jsontools.ErrUnexpectedEOF},
+		{`{"key":trap}`, jsontools.ErrInvalidToken},
+		{`{"key"::1}`, jsontools.ErrInvalidToken},
+		{`{"key"[1]}`, jsontools.ErrInvalidToken},
+		{`{"key":x}`, jsontools.ErrInvalidToken},
+		{`[1, +2]`, jsontools.ErrInvalidToken},
+		{`[1, .2]`, jsontools.ErrInvalidToken},
+		{`[1, 02]`, jsontools.ErrInvalidNumber},
+		{"{\"key\":\"\xff\"}", jsontools.ErrInvalidUTF8},
+		{strings.Repeat("[", jsontools.DefaultMaxDepth+1), jsontools.ErrDepthExceeded},
+	}
+	for _, c := range cases {
+		parser := jsontools.NewJsonParser([]byte(c.src), func(ctx jsontools.HandlerContext) error {
+			return nil
+		})
+		err := parser.Parse()
+		require.ErrorIs(t, err, c.expected, c.src)
+		var syntaxErr *jsontools.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+	}
+
+	src := `{"a":[[{"b":[1]}]]}`
+	parser := jsontools.NewJsonParser([]byte(src), func(ctx jsontools.HandlerContext) error {
+		return nil
+	})
+	parser.SetMaxDepth(5)
+	require.NoError(t, parser.Parse())
+
+	parser = jsontools.NewJsonParser([]byte(src), func(ctx jsontools.HandlerContext) error {
+		return nil
+	})
+	parser.SetMaxDepth(4)
+	err := parser.Parse()
+	require.ErrorIs(t, err, jsontools.ErrDepthExceeded)
+	var syntaxErr *jsontools.SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	require.Equal(t, int64(12), syntaxErr.Offset)
+}
+
+func TestParserSyntaxError(t *testing.T) {
+	src := "{\n\t\"key\": \"value\",\n\t\"arr\": [1, 2}\n}"
+	parser := jsontools.NewJsonParser([]byte(src), func(ctx jsontools.HandlerContext) error {
+		require.Equal(t, string(ctx.Value), src[ctx.Offset:ctx.Offset+int64(len(ctx.Value))])
+		return nil
+	})
+	err := parser.Parse()
+	var syntaxErr *jsontools.SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	require.Equal(t, "invalid '}'", syntaxErr.Msg)
+	require.Equal(t, int64(32), syntaxErr.Offset)
+	require.Equal(t, 3, syntaxErr.Line)
+	require.Equal(t, 14, syntaxErr.Column)
+	require.Equal(t, "\",\n\t\"arr\": [1, 2}\n}", syntaxErr.Excerpt)
+	require.Equal(t, `invalid '}' at line 3, column 14 (offset 32) near "\",\n\t\"arr\": [1, 2}\n}"`, err.Error())
+}
+
 func BenchmarkParser(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,106 @@
+package jsontools
+
+import (
+	"strconv"
+)
+
+// PathElement is an object key or an array index of Path.
+type PathElement struct {
+	Kind  Kind   // KindObjectKey or KindArrayValue
+	Key   []byte // decoded object key, valid if Kind is KindObjectKey
+	Index int    // array index, valid if Kind is KindArrayValue
+}
+
+// Path is the location of a token from the root of json, eg: $.items[3].password.
+//
+// The path passed to the handler is reused by the parser, it's only valid
+// inside the handler, use Clone to keep it.
+type Path []PathElement
+
+// String returns the path in JSONPath notation, keys which are not
+// identifiers are written in brackets, eg: $.items[3]['first name'].
+func (p Path) String() string {
+	return string(p.AppendTo(make([]byte, 0, 32)))
+}
+
+// AppendTo appends the string form of path to dst.
+func (p Path) AppendTo(dst []byte) []byte {
+	dst = append(dst, '$')
+	for _, e := range p {
+		if e.Kind == KindArrayValue {
+			dst = append(dst, '[')
+			dst = strconv.AppendInt(dst, int64(e.Index), 10)
+			dst = append(dst, ']')
+			continue
+		}
+		if isIdentifier(e.Key) {
+			dst = append(dst, '.')
+			dst = append(dst, e.Key...)
+			continue
+		}
+		dst = append(dst, '[', '\'')
+		for _, c := range e.Key {
+			if c == '\'' || c == '\\' {
+				dst = append(dst, '\\')
+			}
+			dst = append(dst, c)
+		}
+		dst = append(dst, '\'', ']')
+	}
+	return dst
+}
+
+// Clone returns a deep copy of path.
+func (p Path) Clone() Path {
+	c := make(Path, len(p))
+	for i, e := range p {
+		c[i] = PathElement{Kind: e.Kind, Index: e.Index}
+		if e.Key != nil {
+			c[i].Key = append([]byte(nil), e.Key...)
+		}
+	}
+	return c
+}
+
+func isIdentifier(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for i, c := range key {
+		switch {
+		case c == '_' || c == '$',
+			c >= 'a' && c <= 'z',
+			c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// pushPath appends an element to the path. The key is decoded only if
+// SetPathKeys, onto the stack of keys shared by all the elements, the keys
+// of the elements stay valid even if the stack grows, since the old buffer
+// is not modified.
+func (t *jsonParser) pushPath(kind Kind, quotedKey []byte) {
+	e := PathElement{Kind: kind}
+	if kind == KindObjectKey && t.pathKeys {
+		if t.keys == nil {
+			t.keys = make([]byte, 0, 256)
+		}
+		start := len(t.keys)
+		t.keys = appendUnquoted(t.keys, quotedKey)
+		e.Key = t.keys[start:len(t.keys):len(t.keys)]
+	}
+	t.path = append(t.path, e)
+}
+
+func (t *jsonParser) popPath() {
+	n := len(t.path) - 1
+	t.keys = t.keys[:len(t.keys)-len(t.path[n].Key)]
+	t.path = t.path[:n]
+}
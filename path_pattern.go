@@ -0,0 +1,196 @@
+package jsontools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type segmentKind byte
+
+const (
+	segmentKey segmentKind = iota
+	segmentIndex
+	segmentWildcard
+)
+
+// pathSegment matches one element of Path.
+type pathSegment struct {
+	kind      segmentKind
+	key       string
+	index     int
+	recursive bool // matches after any number of elements, eg: $..key
+}
+
+func (s pathSegment) matchElement(e PathElement) bool {
+	switch s.kind {
+	case segmentKey:
+		return e.Kind == KindObjectKey && string(e.Key) == s.key
+	case segmentIndex:
+		return e.Kind == KindArrayValue && e.Index == s.index
+	default:
+		return true
+	}
+}
+
+// pathPattern is a compiled subset of JSONPath, made of child keys
+// ($.a.b, $['a b']), array indices ($.a[0]), wildcards ($.a[*], $.a.*)
+// and recursive descent ($..secret).
+type pathPattern []pathSegment
+
+// match reports whether the whole path matches the pattern.
+func (p pathPattern) match(path Path) bool {
+	if len(p) == 0 {
+		return len(path) == 0
+	}
+	seg := p[0]
+	if seg.recursive {
+		for i := range path {
+			if seg.matchElement(path[i]) && p[1:].match(path[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || !seg.matchElement(path[0]) {
+		return false
+	}
+	return p[1:].match(path[1:])
+}
+
+// matchPrefix reports whether path is a proper prefix of some path which
+// matches the pattern, that is, a value at path may contain matched values.
+func (p pathPattern) matchPrefix(path Path) bool {
+	if len(p) == 0 {
+		return false
+	}
+	if len(path) == 0 {
+		return true
+	}
+	seg := p[0]
+	if seg.recursive {
+		// the segment may match an element below path
+		return true
+	}
+	if !seg.matchElement(path[0]) {
+		return false
+	}
+	return p[1:].matchPrefix(path[1:])
+}
+
+func matchAnyPath(patterns []pathPattern, path Path) bool {
+	for _, p := range patterns {
+		if p.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePaths(exprs []string) ([]pathPattern, error) {
+	patterns := make([]pathPattern, 0, len(exprs))
+	for _, expr := range exprs {
+		p, err := compilePath(expr)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func compilePath(expr string) (pathPattern, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("%w %q: must start with '$'", ErrInvalidPath, expr)
+	}
+
+	var p pathPattern
+	for i := 1; i < len(expr); {
+		var seg pathSegment
+		switch expr[i] {
+		case '.':
+			i++
+			if i < len(expr) && expr[i] == '.' {
+				seg.recursive = true
+				i++
+			}
+			if seg.recursive && i < len(expr) && expr[i] == '[' {
+				next, err := parseBracket(expr, i, &seg)
+				if err != nil {
+					return nil, err
+				}
+				i = next
+				break
+			}
+			j := i
+			for j < len(expr) && expr[j] != '.' && expr[j] != '[' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("%w %q: empty key at %d", ErrInvalidPath, expr, i)
+			}
+			if name := expr[i:j]; name == "*" {
+				seg.kind = segmentWildcard
+			} else {
+				seg.kind = segmentKey
+				seg.key = name
+			}
+			i = j
+
+		case '[':
+			next, err := parseBracket(expr, i, &seg)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+
+		default:
+			return nil, fmt.Errorf("%w %q: unexpected %q at %d", ErrInvalidPath, expr, expr[i], i)
+		}
+		p = append(p, seg)
+	}
+	return p, nil
+}
+
+// parseBracket parses [*], [3], ['key'] or ["key"] at expr[i], and returns
+// the position after it.
+func parseBracket(expr string, i int, seg *pathSegment) (int, error) {
+	i++ // skip '['
+	if i >= len(expr) {
+		return 0, fmt.Errorf("%w %q: missing ']'", ErrInvalidPath, expr)
+	}
+
+	switch quote := expr[i]; quote {
+	case '\'', '"':
+		var key strings.Builder
+		for i++; i < len(expr) && expr[i] != quote; i++ {
+			if expr[i] == '\\' && i+1 < len(expr) {
+				i++
+			}
+			key.WriteByte(expr[i])
+		}
+		if i+1 >= len(expr) || expr[i+1] != ']' {
+			return 0, fmt.Errorf("%w %q: unterminated key", ErrInvalidPath, expr)
+		}
+		seg.kind = segmentKey
+		seg.key = key.String()
+		return i + 2, nil
+	}
+
+	end := strings.IndexByte(expr[i:], ']')
+	if end < 0 {
+		return 0, fmt.Errorf("%w %q: missing ']'", ErrInvalidPath, expr)
+	}
+	inner := expr[i : i+end]
+	if inner == "*" {
+		seg.kind = segmentWildcard
+		return i + end + 1, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("%w %q: invalid index %q", ErrInvalidPath, expr, inner)
+	}
+	seg.kind = segmentIndex
+	seg.index = index
+	return i + end + 1, nil
+}
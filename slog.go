@@ -0,0 +1,121 @@
+//go:build go1.21
+
+package jsontools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+)
+
+// invalidJsonValue is logged instead of the json which can't be parsed,
+// since the raw input may not be safe to log.
+const invalidJsonValue = "!INVALID JSON"
+
+// rawJson is logged as embedded json by slog.JSONHandler, and as text by
+// slog.TextHandler. The empty output of empty input is logged as null.
+type rawJson []byte
+
+func (j rawJson) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j rawJson) MarshalText() ([]byte, error) {
+	return j, nil
+}
+
+// LogValuer returns a slog.LogValuer of data, which is modified by m when
+// it's logged, and is embedded as json by slog.JSONHandler rather than an
+// escaped string. data is never modified, even if m is inplace.
+func (m *JsonModifier) LogValuer(data []byte) slog.LogValuer {
+	return jsonLogValuer{m: m, data: data}
+}
+
+type jsonLogValuer struct {
+	m    *JsonModifier
+	data []byte
+}
+
+func (v jsonLogValuer) LogValue() slog.Value {
+	return v.m.slogValue(v.data)
+}
+
+func (m *JsonModifier) slogValue(data []byte) slog.Value {
+	dst, err := m.AppendModified(nil, data)
+	if err != nil {
+		return slog.StringValue(invalidJsonValue)
+	}
+	return slog.AnyValue(rawJson(dst))
+}
+
+// NewSlogHandler returns a slog.Handler which modifies the json attributes
+// by m, then passes the record to next. The json attributes are the values
+// of json.RawMessage, and []byte starting with '{' or '['. They are
+// embedded as json by slog.JSONHandler rather than escaped strings.
+func NewSlogHandler(next slog.Handler, m *JsonModifier) slog.Handler {
+	return &slogHandler{next: next, m: m}
+}
+
+type slogHandler struct {
+	next slog.Handler
+	m    *JsonModifier
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	record := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		record.AddAttrs(h.modifyAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, record)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	modified := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		modified[i] = h.modifyAttr(a)
+	}
+	return &slogHandler{next: h.next.WithAttrs(modified), m: h.m}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	return &slogHandler{next: h.next.WithGroup(name), m: h.m}
+}
+
+func (h *slogHandler) modifyAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		modified := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			modified[i] = h.modifyAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(modified...)}
+
+	case slog.KindAny:
+		switch data := v.Any().(type) {
+		case json.RawMessage:
+			return slog.Attr{Key: a.Key, Value: h.m.slogValue(data)}
+		case []byte:
+			if isJsonBytes(data) {
+				return slog.Attr{Key: a.Key, Value: h.m.slogValue(data)}
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// isJsonBytes reports whether data looks like a json object or array.
+func isJsonBytes(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
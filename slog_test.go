@@ -0,0 +1,70 @@
+//go:build go1.21
+
+package jsontools_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/WqyJh/jsontools"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogHandler(t *testing.T) {
+	modifier := jsontools.NewJsonModifier(jsontools.WithFilterKeys("password"), jsontools.WithFieldLengthLimit(5))
+	body := `{"user":"1234567890","password":"x"}`
+
+	var buf bytes.Buffer
+	logger := slog.New(jsontools.NewSlogHandler(slog.NewJSONHandler(&buf, nil), modifier))
+	logger.With("req", json.RawMessage(body)).WithGroup("g").Info("hello",
+		"resp", []byte(body),
+		"raw", []byte("not json"),
+		"str", body,
+		slog.Group("sub", "body", json.RawMessage(body), "n", 1),
+		"bad", json.RawMessage(`{"password":`),
+	)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	expected := map[string]any{"user": "12345"}
+	require.Equal(t, expected, record["req"])
+	group := record["g"].(map[string]any)
+	require.Equal(t, expected, group["resp"])
+	require.Equal(t, "bm90IGpzb24=", group["raw"]) // []byte is base64 encoded by slog
+	require.Equal(t, body, group["str"])
+	require.Equal(t, map[string]any{"body": expected, "n": float64(1)}, group["sub"])
+	require.Equal(t, "!INVALID JSON", group["bad"])
+
+	// empty input is logged as null
+	buf.Reset()
+	logger.Info("hello", "nil", json.RawMessage(nil), "empty", json.RawMessage{})
+	record = nil
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), buf.String())
+	require.Contains(t, record, "nil")
+	require.Nil(t, record["nil"])
+	require.Contains(t, record, "empty")
+	require.Nil(t, record["empty"])
+
+	buf.Reset()
+	logger = slog.New(jsontools.NewSlogHandler(slog.NewTextHandler(&buf, nil), modifier))
+	logger.Info("hello", "req", json.RawMessage(body))
+	require.Contains(t, buf.String(), `req="{\"user\":\"12345\"}"`)
+}
+
+func TestJsonModifierLogValuer(t *testing.T) {
+	modifier := jsontools.NewJsonModifier(jsontools.WithFilterKeys("password"), jsontools.WithInplace(true))
+	body := []byte(`{"user":"a","password":"x"}`)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Info("hello", "body", modifier.LogValuer(body))
+	require.True(t, strings.HasSuffix(buf.String(), `"msg":"hello","body":{"user":"a"}}`+"\n"), buf.String())
+	require.Equal(t, `{"user":"a","password":"x"}`, string(body))
+
+	buf.Reset()
+	logger.Info("hello", "body", modifier.LogValuer(nil))
+	require.True(t, strings.HasSuffix(buf.String(), `"msg":"hello","body":null}`+"\n"), buf.String())
+}
@@ -0,0 +1,132 @@
+package jsontools
+
+import (
+	"errors"
+	"io"
+)
+
+// streamFlushSize is the size of output buffered before written to the
+// writer by ModifyTo and ModifyStream.
+const streamFlushSize = 4096
+
+// ModifyTo writes the modified json of data to w as it goes, instead of
+// returning it. data is never modified, WithInplace is ignored. The output
+// written before an error is not reverted.
+func (m *JsonModifier) ModifyTo(w io.Writer, data []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	state := m.newStreamState(w)
+	parser := NewJsonParser(data, state.handleStream)
+	parser.SetPathKeys(m.pathKeys)
+	return state.finish(parser.Parse())
+}
+
+// ModifyStream is like ModifyTo, but reads json from r, so neither the
+// input nor the output is held in memory as a whole, unless
+// WithMaxOutputBytes is set, which buffers the output up to its size.
+func (m *JsonModifier) ModifyStream(w io.Writer, r io.Reader) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	state := m.newStreamState(w)
+	input := &countReader{r: r}
+	parser := NewJsonReaderParser(input, state.handleStream)
+	parser.SetPathKeys(m.pathKeys)
+	err := parser.Parse()
+	if input.n == 0 && errors.Is(err, ErrUnexpectedEOF) {
+		// nothing is read, same as the empty data of ModifyJson
+		return nil
+	}
+	return state.finish(err)
+}
+
+// countReader counts the bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (m *JsonModifier) newStreamState(w io.Writer) *modifyState {
+	return &modifyState{
+		m:   m,
+		dst: make([]byte, 0, 2*streamFlushSize),
+		w:   w,
+	}
+}
+
+// handleStream handles the token like handle, then flushes the output.
+func (s *modifyState) handleStream(ctx HandlerContext) error {
+	if err := s.handle(ctx); err != nil {
+		return err
+	}
+	if len(s.dst) >= streamFlushSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the head of dst, which won't be changed any more, to w.
+func (s *modifyState) flush() error {
+	if s.m.maxOutput > 0 {
+		// the output may be cut at any safe position, keep all of it
+		return nil
+	}
+	// the last byte may be a comma to be removed
+	n := len(s.dst) - 1
+	if len(s.allowMarks) > 0 && s.allowMarks[0].dstLen < n {
+		// the tentative values may be rolled back
+		n = s.allowMarks[0].dstLen
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	if _, err := s.w.Write(s.dst[:n]); err != nil {
+		s.werr = err
+		return err
+	}
+	s.dst = s.dst[:copy(s.dst, s.dst[n:])]
+	for i := range s.allowMarks {
+		s.allowMarks[i].dstLen -= n
+	}
+	return nil
+}
+
+// finish writes the rest of the output to w, err is the error of parsing.
+func (s *modifyState) finish(err error) error {
+	if s.werr != nil {
+		return s.werr
+	}
+	if err != nil {
+		return err
+	}
+	if s.cut && len(s.dst) == 0 {
+		return ErrOutputTooSmall
+	}
+	_, err = s.w.Write(s.dst)
+	return err
+}
+
+// ModifyTo is a wrapper of JsonModifier.ModifyTo.
+func ModifyTo(w io.Writer, data []byte, opts ...JsonModifierOption) error {
+	m := NewJsonModifier(opts...)
+	return m.ModifyTo(w, data)
+}
+
+// ModifyStream is a wrapper of JsonModifier.ModifyStream.
+func ModifyStream(w io.Writer, r io.Reader, opts ...JsonModifierOption) error {
+	m := NewJsonModifier(opts...)
+	return m.ModifyStream(w, r)
+}
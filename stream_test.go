@@ -0,0 +1,99 @@
+package jsontools_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/WqyJh/jsontools"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModifyStream(t *testing.T) {
+	large := "[" + strings.Repeat(src1+",", 50) + `{"a":1}]`
+	inputs := []string{src1, large, `{}`, `[]`}
+	optsList := [][]jsontools.JsonModifierOption{
+		nil,
+		{jsontools.WithFieldLengthLimit(5), jsontools.WithTruncateSuffix("...")},
+		{jsontools.WithFilterKeys("Field2", "Field3", "Field10")},
+		{jsontools.WithAllowKeys("Field1", "$[*].Field4")},
+		{jsontools.WithRedactKeys("***", "Field4"), jsontools.WithArrayLengthLimit(2), jsontools.WithArrayTruncateMarker("+{more}")},
+		{jsontools.WithHashKeys([]byte("secret"), "Field5"), jsontools.WithMaxDepth(3)},
+		{jsontools.WithMaxOutputBytes(500)},
+	}
+	for _, input := range inputs {
+		for _, opts := range optsList {
+			expected, err := jsontools.ModifyJson([]byte(input), opts...)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, jsontools.ModifyTo(&buf, []byte(input), opts...))
+			require.Equal(t, string(expected), buf.String())
+
+			buf.Reset()
+			require.NoError(t, jsontools.ModifyStream(&buf, strings.NewReader(input), opts...))
+			require.Equal(t, string(expected), buf.String())
+
+			buf.Reset()
+			require.NoError(t, jsontools.ModifyStream(&buf, iotest.OneByteReader(strings.NewReader(input)), opts...))
+			require.Equal(t, string(expected), buf.String())
+		}
+	}
+}
+
+func TestModifyStreamError(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jsontools.ModifyStream(&buf, strings.NewReader("")))
+	require.NoError(t, jsontools.ModifyTo(&buf, nil))
+	require.Empty(t, buf.String())
+
+	// whitespace only is not empty, for all of the entry points
+	_, err := jsontools.ModifyJson([]byte(" \n"))
+	require.ErrorIs(t, err, jsontools.ErrUnexpectedEOF)
+	require.ErrorIs(t, jsontools.ModifyTo(&buf, []byte(" \n")), jsontools.ErrUnexpectedEOF)
+	require.ErrorIs(t, jsontools.ModifyStream(&buf, strings.NewReader(" \n")), jsontools.ErrUnexpectedEOF)
+	require.Empty(t, buf.String())
+
+	// truncated scalars are not empty
+	for _, input := range []string{`"abc`, `tru`, `n`, ` "`} {
+		_, err := jsontools.ModifyJson([]byte(input))
+		require.Error(t, err, input)
+		err = jsontools.ModifyStream(&buf, strings.NewReader(input))
+		var syntaxErr *jsontools.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr, input)
+		require.Empty(t, buf.String())
+	}
+
+	err = jsontools.ModifyStream(&buf, strings.NewReader(`{"a":[1,2}`))
+	var syntaxErr *jsontools.SyntaxError
+	require.ErrorAs(t, err, &syntaxErr)
+	require.Equal(t, int64(9), syntaxErr.Offset)
+
+	readErr := errors.New("read error")
+	err = jsontools.ModifyStream(&buf, iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader(`{"a":1}`))))
+	require.ErrorIs(t, err, iotest.ErrTimeout)
+	err = jsontools.ModifyStream(&buf, iotest.ErrReader(readErr))
+	require.ErrorIs(t, err, readErr)
+
+	writeErr := errors.New("write error")
+	large := "[" + strings.Repeat(src1+",", 50) + `{"a":1}]`
+	err = jsontools.ModifyTo(errWriter{writeErr}, []byte(large))
+	require.Equal(t, writeErr, err)
+	err = jsontools.ModifyStream(errWriter{writeErr}, strings.NewReader(`{"a":1}`))
+	require.Equal(t, writeErr, err)
+
+	_, err = jsontools.ModifyJson([]byte(`{"a":1}`), jsontools.WithMaxOutputBytes(1))
+	require.ErrorIs(t, err, jsontools.ErrOutputTooSmall)
+	err = jsontools.ModifyTo(&buf, []byte(`{"a":1}`), jsontools.WithMaxOutputBytes(1))
+	require.ErrorIs(t, err, jsontools.ErrOutputTooSmall)
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
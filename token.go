@@ -1,8 +1,9 @@
 package jsontools
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
+	"io"
 	"unicode/utf8"
 )
 
@@ -23,6 +24,8 @@ const (
 	SepColon              // :
 	SepComma              // ,
 	EndJson               // EOF
+
+	invalid TokenType = 0xff // unexpected character, internal only
 )
 
 func (t TokenType) String() string {
@@ -60,13 +63,122 @@ func (t TokenType) String() string {
 	}
 }
 
+const (
+	defaultReaderBufferSize  = 4096
+	maxConsecutiveEmptyReads = 100
+)
+
 type jsonTokenizer struct {
 	data []byte
+	src  *readerSource // optional, data is refilled from it
 
 	off     int
 	current TokenType
-	start   int    // token start
-	value   []byte // token value
+	start   int // token start
+	num     numberState
+
+	base      int64 // offset of data[0] in the input
+	lines     int   // lines before data[0]
+	lineStart int64 // offset of the line containing data[0]
+	offset    int64 // offset of the last token
+}
+
+// readerSource is the reader of NewJsonReaderTokenizer and its buffer, which
+// data shares. data is only resliced in place and never passed to the
+// reader, and the read error is kept here rather than in jsonTokenizer,
+// otherwise the input of NewJsonTokenizer escapes to heap.
+type readerSource struct {
+	r   io.Reader
+	buf []byte
+	err error // sticky read error of r
+}
+
+// numberState is the position inside a number token.
+//
+//	number = [ minus ] int [ frac ] [ exp ]
+//	int    = zero / ( digit1-9 *DIGIT )
+//	frac   = decimal-point 1*DIGIT
+//	exp    = e [ minus / plus ] 1*DIGIT
+type numberState byte
+
+const (
+	numInvalid  numberState = iota
+	numMinus                // -
+	numZero                 // 0
+	numInt                  // 123
+	numDot                  // 1.
+	numFrac                 // 1.23
+	numExp                  // 1e
+	numExpSign              // 1e-
+	numExpDigit             // 1e-10
+)
+
+// next returns the state after b, or numInvalid if b can't continue the number.
+func (s numberState) next(b rune) numberState {
+	switch s {
+	case numMinus:
+		if b == '0' {
+			return numZero
+		}
+		if b >= '1' && b <= '9' {
+			return numInt
+		}
+	case numZero:
+		if b == '.' {
+			return numDot
+		}
+		if b == 'e' || b == 'E' {
+			return numExp
+		}
+	case numInt:
+		if isDigit(b, false) {
+			return numInt
+		}
+		if b == '.' {
+			return numDot
+		}
+		if b == 'e' || b == 'E' {
+			return numExp
+		}
+	case numDot, numFrac:
+		if isDigit(b, false) {
+			return numFrac
+		}
+		if s == numFrac && (b == 'e' || b == 'E') {
+			return numExp
+		}
+	case numExp:
+		if b == '-' || b == '+' {
+			return numExpSign
+		}
+		if isDigit(b, false) {
+			return numExpDigit
+		}
+	case numExpSign, numExpDigit:
+		if isDigit(b, false) {
+			return numExpDigit
+		}
+	}
+	return numInvalid
+}
+
+// complete reports whether a number may end in this state.
+func (s numberState) complete() bool {
+	switch s {
+	case numZero, numInt, numFrac, numExpDigit:
+		return true
+	}
+	return false
+}
+
+// isNumberByte reports whether b may appear inside a number, a number
+// followed by such a byte is malformed, eg: 01, 1.2.3, 1e2e3.
+func isNumberByte(b rune) bool {
+	switch b {
+	case '.', 'e', 'E', '+', '-':
+		return true
+	}
+	return isDigit(b, false)
 }
 
 func NewJsonTokenizer(data []byte) *jsonTokenizer {
@@ -76,6 +188,95 @@ func NewJsonTokenizer(data []byte) *jsonTokenizer {
 	}
 }
 
+// NewJsonReaderTokenizer returns a tokenizer which reads json from r.
+// Unlike NewJsonTokenizer, the value returned by Next is only valid
+// until the next call of Next, since the underlying buffer is reused.
+func NewJsonReaderTokenizer(r io.Reader) *jsonTokenizer {
+	src := &readerSource{r: r, buf: make([]byte, defaultReaderBufferSize)}
+	return &jsonTokenizer{
+		data:    src.buf[:0],
+		src:     src,
+		current: Init,
+	}
+}
+
+// fill reads more data from the reader, dropping the bytes which are no
+// longer referenced by the pending token. It reports whether any new data
+// was read.
+func (t *jsonTokenizer) fill() bool {
+	if t.src == nil || t.src.err != nil {
+		return false
+	}
+	src := t.src
+
+	keep := t.off
+	if t.current != Init && t.current != EndJson && t.start < keep {
+		keep = t.start
+	}
+	if keep > 0 {
+		t.lines += bytes.Count(t.data[:keep], []byte{'\n'})
+		if i := bytes.LastIndexByte(t.data[:keep], '\n'); i >= 0 {
+			t.lineStart = t.base + int64(i) + 1
+		}
+		t.base += int64(keep)
+		n := copy(t.data, t.data[keep:])
+		t.data = t.data[:n]
+		t.off -= keep
+		t.start -= keep
+		if t.start < 0 {
+			t.start = 0
+		}
+	}
+
+	if len(t.data) == len(src.buf) {
+		// the pending token is larger than the buffer
+		buf := make([]byte, 2*len(src.buf)+1)
+		copy(buf, t.data)
+		src.buf = buf
+		t.data = buf[:len(t.data)]
+	}
+
+	for i := 0; i < maxConsecutiveEmptyReads; i++ {
+		n, err := src.r.Read(src.buf[len(t.data):])
+		t.data = t.data[:len(t.data)+n]
+		if err != nil {
+			src.err = err
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	src.err = io.ErrNoProgress
+	return false
+}
+
+// readError returns the error of the reader which stops the input early,
+// nil if the input ends normally. A token cut by it is not a syntax error.
+func (t *jsonTokenizer) readError() error {
+	if t.src == nil || t.src.err == io.EOF {
+		return nil
+	}
+	return t.src.err
+}
+
+// ensure tries to make at least n bytes available after t.off.
+func (t *jsonTokenizer) ensure(n int) {
+	for len(t.data)-t.off < n && t.fill() {
+	}
+}
+
+// decodeRune decodes the rune at t.off, refilling the buffer if the rune
+// straddles the end of it.
+func (t *jsonTokenizer) decodeRune() (rune, int) {
+	if t.off < len(t.data) && t.data[t.off] < utf8.RuneSelf {
+		return rune(t.data[t.off]), 1
+	}
+	for t.src != nil && !utf8.FullRune(t.data[t.off:]) && t.fill() {
+	}
+	return utf8.DecodeRune(t.data[t.off:])
+}
+
 func isDigit(b rune, includeSign bool) bool {
 	if b >= 48 && b <= 57 {
 		return true
@@ -87,16 +288,16 @@ func isDigit(b rune, includeSign bool) bool {
 func (t *jsonTokenizer) nextStatus(b rune, size int) TokenType {
 	switch b {
 	case '{':
-		t.value = t.data[t.off : t.off+size]
+		t.start = t.off
 		return BeginObject
 	case '}':
-		t.value = t.data[t.off : t.off+size]
+		t.start = t.off
 		return EndObject
 	case '[':
-		t.value = t.data[t.off : t.off+size]
+		t.start = t.off
 		return BeginArray
 	case ']':
-		t.value = t.data[t.off : t.off+size]
+		t.start = t.off
 		return EndArray
 	case 'n':
 		t.start = t.off
@@ -108,10 +309,10 @@ func (t *jsonTokenizer) nextStatus(b rune, size int) TokenType {
 		t.start = t.off
 		return False
 	case ':':
-		t.value = t.data[t.off : t.off+size]
+		t.start = t.off
 		return SepColon
 	case ',':
-		t.value = t.data[t.off : t.off+size]
+		t.start = t.off
 		return SepComma
 	case '"':
 		t.start = t.off
@@ -119,137 +320,160 @@ func (t *jsonTokenizer) nextStatus(b rune, size int) TokenType {
 	}
 	if isDigit(b, true) {
 		t.start = t.off
+		switch b {
+		case '-':
+			t.num = numMinus
+		case '0':
+			t.num = numZero
+		default:
+			t.num = numInt
+		}
 		return Number
 	}
-	return Init
+	switch b {
+	case ' ', '\t', '\n', '\r':
+		return Init
+	}
+	t.start = t.off
+	return invalid
 }
 
 func (t *jsonTokenizer) pendingNextStatus() TokenType {
 	return Init
 }
 
+// Offset returns the byte offset of the last token returned by Next in the input.
+func (t *jsonTokenizer) Offset() int64 {
+	return t.offset
+}
+
+// literalError reports the malformed literal true, false or null. It's an
+// unexpected EOF if the input ends with a prefix of the literal, or the read
+// error if the input is stopped by it.
+func (t *jsonTokenizer) literalError(literal string, msg string) error {
+	err := ErrInvalidToken
+	if rest := t.data[t.start:]; len(rest) < len(literal) && literal[:len(rest)] == string(rest) {
+		if rerr := t.readError(); rerr != nil {
+			return rerr
+		}
+		err = ErrUnexpectedEOF
+	}
+	return t.syntaxError(t.start, err, fmt.Sprintf("%s '%s'", msg, string(t.data[t.start:])))
+}
+
+func (t *jsonTokenizer) invalidCharError() error {
+	t.current = EndJson
+	r, _ := utf8.DecodeRune(t.data[t.start:])
+	return t.syntaxError(t.start, ErrInvalidToken, fmt.Sprintf("invalid character %q", r))
+}
+
+func (t *jsonTokenizer) emit(token TokenType, start, end int) (TokenType, []byte, error) {
+	t.offset = t.base + int64(start)
+	return token, t.data[start:end], nil
+}
+
 func (t *jsonTokenizer) Next() (TokenType, []byte, error) {
-	for t.off < len(t.data) {
-		b, size := utf8.DecodeRune(t.data[t.off:])
+	for t.off < len(t.data) || t.fill() {
+		b, size := t.decodeRune()
 
 		switch t.current {
 		case Init:
 			t.current = t.nextStatus(b, size)
 			t.off += size
 
-		case BeginObject:
-			value := t.value
-			t.current = t.nextStatus(b, size)
-			t.off += size
-			return BeginObject, value, nil
+		case invalid:
+			return Init, nil, t.invalidCharError()
 
-		case EndObject:
-			value := t.value
+		case BeginObject,
+			EndObject,
+			BeginArray,
+			EndArray,
+			SepColon,
+			SepComma:
+			token, start := t.current, t.start
 			t.current = t.nextStatus(b, size)
 			t.off += size
-			return EndObject, value, nil
+			return t.emit(token, start, start+1)
 
 		case String:
 			slashCount := 0
-			for j := t.off; j < len(t.data); {
-				b, size := utf8.DecodeRune(t.data[j:])
-				switch b {
-				case '\\':
-					slashCount++
-				case '"':
-					if slashCount%2 == 0 {
-						value := t.data[t.start : j+size]
-						t.current = t.pendingNextStatus()
-						t.off = j + size
-						return String, value, nil
+			for t.off < len(t.data) || t.fill() {
+				switch c := t.data[t.off]; {
+				case c >= utf8.RuneSelf:
+					if _, size := t.decodeRune(); size > 1 {
+						t.off += size
+						slashCount = 0
+						continue
 					}
+					return Init, nil, t.syntaxError(t.off, ErrInvalidUTF8, fmt.Sprintf("invalid utf8 in string '%s'", string(t.data[t.start:t.off])))
+				case c == '\\':
+					slashCount++
+				case c == '"' && slashCount%2 == 0:
+					t.off++
+					t.current = t.pendingNextStatus()
+					return t.emit(String, t.start, t.off)
 				default:
 					slashCount = 0
 				}
-				j += size
+				t.off++
 			}
 
-			return Init, nil, fmt.Errorf("invalid string '%s'", string(t.data[t.start:]))
-
-		case Number:
-			if b == '.' {
-				t.current = Float
-				t.off += size
-			} else if isDigit(b, false) {
-				t.off += size
-			} else {
-				value := t.data[t.start:t.off]
-				t.current = t.nextStatus(b, size)
-				t.off += size
-				return Number, value, nil
+			if err := t.readError(); err != nil {
+				return Init, nil, err
 			}
+			return Init, nil, t.syntaxError(t.start, ErrUnexpectedEOF, fmt.Sprintf("invalid string '%s'", string(t.data[t.start:])))
 
-		case Float:
-			if b == '.' {
-				return Init, nil, errors.New("invalid float")
-			}
-			if isDigit(b, false) {
-				t.off += size
-			} else {
-				value := t.data[t.start:t.off]
-				t.current = t.nextStatus(b, size)
+		case Number,
+			Float:
+			if next := t.num.next(b); next != numInvalid {
+				if next == numDot || next == numExp {
+					t.current = Float
+				}
+				t.num = next
 				t.off += size
-				return Float, value, nil
+				continue
 			}
-
-		case SepColon:
-			value := t.value
-			t.current = t.nextStatus(b, size)
-			t.off += size
-			return SepColon, value, nil
-
-		case SepComma:
-			value := t.value
-			t.current = t.nextStatus(b, size)
-			t.off += size
-			return SepComma, value, nil
-
-		case BeginArray:
-			value := t.value
-			t.current = t.nextStatus(b, size)
-			t.off += size
-			return BeginArray, value, nil
-
-		case EndArray:
-			value := t.value
+			if !t.num.complete() || isNumberByte(b) {
+				return Init, nil, t.syntaxError(t.off, ErrInvalidNumber, fmt.Sprintf("%s '%s'", ErrInvalidNumber, string(t.data[t.start:t.off+size])))
+			}
+			token, start, end := t.current, t.start, t.off
 			t.current = t.nextStatus(b, size)
 			t.off += size
-			return EndArray, value, nil
+			return t.emit(token, start, end)
 
 		case True:
+			t.ensure(3)
 			if t.off+3 <= len(t.data) && t.data[t.off] == 'r' && t.data[t.off+1] == 'u' && t.data[t.off+2] == 'e' {
-				value := t.data[t.start : t.off+3]
 				t.off += 3
 				t.current = t.pendingNextStatus()
-				return True, value, nil
+				return t.emit(True, t.start, t.off)
 			}
-			return Init, nil, fmt.Errorf("invalid bool true '%s'", string(t.data[t.start:]))
+			return Init, nil, t.literalError("true", "invalid bool true")
 
 		case False:
+			t.ensure(4)
 			if t.off+4 <= len(t.data) && t.data[t.off] == 'a' && t.data[t.off+1] == 'l' && t.data[t.off+2] == 's' && t.data[t.off+3] == 'e' {
-				value := t.data[t.start : t.off+4]
 				t.off += 4
 				t.current = t.pendingNextStatus()
-				return False, value, nil
+				return t.emit(False, t.start, t.off)
 			}
-			return Init, nil, fmt.Errorf("invalid bool false '%s'", string(t.data[t.start:]))
+			return Init, nil, t.literalError("false", "invalid bool false")
 
 		case Null:
+			t.ensure(3)
 			if t.off+3 <= len(t.data) && t.data[t.off] == 'u' && t.data[t.off+1] == 'l' && t.data[t.off+2] == 'l' {
-				value := t.data[t.start : t.off+3]
 				t.off += 3
 				t.current = t.pendingNextStatus()
-				return Null, value, nil
+				return t.emit(Null, t.start, t.off)
 			}
-			return Init, nil, fmt.Errorf("invalid null '%s'", string(t.data[t.start:]))
+			return Init, nil, t.literalError("null", "invalid null")
 		}
 	}
 
+	if err := t.readError(); err != nil {
+		return Init, nil, err
+	}
+
 	switch t.current {
 	case BeginObject,
 		EndObject,
@@ -259,46 +483,62 @@ func (t *jsonTokenizer) Next() (TokenType, []byte, error) {
 		SepComma:
 		token := t.current
 		t.current = EndJson
-		return token, t.value, nil
+		return t.emit(token, t.start, t.start+1)
 
-	case EndJson:
+	case invalid:
+		return Init, nil, t.invalidCharError()
+
+	case Init,
+		EndJson:
+		t.current = EndJson
+		t.offset = t.base + int64(len(t.data))
 		return EndJson, nil, nil
 
 	case String:
 		value := t.data[t.start:]
 		t.current = EndJson
 		if len(value) >= 2 && (value[len(value)-1] != '"' || value[len(value)-2] == '\\') {
-			return String, value, nil
+			return t.emit(String, t.start, len(t.data))
 		}
-		return Init, nil, fmt.Errorf("invalid string '%s'", string(value))
+		return Init, nil, t.syntaxError(t.start, ErrUnexpectedEOF, fmt.Sprintf("invalid string '%s'", string(value)))
 
 	case True:
 		value := t.data[t.start:]
 		t.current = EndJson
 		if len(value) == 4 && value[1] == 'r' && value[2] == 'u' && value[3] == 'e' {
-			return True, value, nil
+			return t.emit(True, t.start, len(t.data))
 		}
-		return Init, nil, fmt.Errorf("invalid bool true '%s'", string(value))
+		return Init, nil, t.syntaxError(t.start, ErrUnexpectedEOF, fmt.Sprintf("invalid bool true '%s'", string(value)))
 
 	case False:
 		value := t.data[t.start:]
 		t.current = EndJson
 		if len(value) == 5 && value[1] == 'a' && value[2] == 'l' && value[3] == 's' && value[4] == 'e' {
-			return False, value, nil
+			return t.emit(False, t.start, len(t.data))
 		}
-		return Init, nil, fmt.Errorf("invalid bool false '%s'", string(value))
+		return Init, nil, t.syntaxError(t.start, ErrUnexpectedEOF, fmt.Sprintf("invalid bool false '%s'", string(value)))
 
 	case Null:
 		value := t.data[t.start:]
 		t.current = EndJson
 		if len(value) == 4 && value[1] == 'u' && value[2] == 'l' && value[3] == 'l' {
-			return Null, value, nil
+			return t.emit(Null, t.start, len(t.data))
+		}
+		return Init, nil, t.syntaxError(t.start, ErrUnexpectedEOF, fmt.Sprintf("invalid null '%s'", string(value)))
+
+	case Number,
+		Float:
+		token := t.current
+		value := t.data[t.start:]
+		t.current = EndJson
+		if !t.num.complete() {
+			return Init, nil, t.syntaxError(len(t.data), ErrInvalidNumber, fmt.Sprintf("%s '%s'", ErrInvalidNumber, string(value)))
 		}
-		return Init, nil, fmt.Errorf("invalid null '%s'", string(value))
+		return t.emit(token, t.start, len(t.data))
 
 	default:
 		token := t.current
 		t.current = EndJson
-		return token, t.data[t.start:], nil
+		return t.emit(token, t.start, len(t.data))
 	}
 }
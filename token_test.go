@@ -3,7 +3,11 @@ package jsontools_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/WqyJh/jsontools"
 	"github.com/stretchr/testify/require"
@@ -92,7 +96,7 @@ func TestTokenizerError(t *testing.T) {
 		src      string
 		expected string
 	}{
-		{`1..`, "invalid float"},
+		{`1..`, "invalid number '1..'"},
 		{`t`, "invalid bool true 't'"},
 		{`tr`, "invalid bool true 'tr'"},
 		{`tru`, "invalid bool true 'tru'"},
@@ -117,8 +121,162 @@ func TestTokenizerError(t *testing.T) {
 		tokenizer := jsontools.NewJsonTokenizer([]byte(c.src))
 		t.Logf("src: '%s'", c.src)
 		_, _, err := tokenizer.Next()
+		var syntaxErr *jsontools.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+		require.Equal(t, c.expected, syntaxErr.Msg)
+	}
+}
+
+func TestTokenizerOffset(t *testing.T) {
+	src := "{\n  \"a\": [1, -2.5e3, true],\n  \"b\": null\n}"
+	expected := []int64{0, 4, 7, 9, 10, 11, 13, 19, 21, 25, 26, 30, 33, 35, 40, 41}
+
+	for _, tokenizer := range []interface {
+		Next() (jsontools.TokenType, []byte, error)
+		Offset() int64
+	}{
+		jsontools.NewJsonTokenizer([]byte(src)),
+		jsontools.NewJsonReaderTokenizer(iotest.OneByteReader(strings.NewReader(src))),
+	} {
+		var offsets []int64
+		for {
+			token, value, err := tokenizer.Next()
+			require.NoError(t, err)
+			offsets = append(offsets, tokenizer.Offset())
+			if token == jsontools.EndJson {
+				break
+			}
+			require.Equal(t, string(value), src[tokenizer.Offset():tokenizer.Offset()+int64(len(value))])
+		}
+		require.Equal(t, expected, offsets)
+	}
+}
+
+func TestTokenizerSyntaxError(t *testing.T) {
+	src := "{\n  \"a\": [1, -2.5e3, true],\n  \"b\": nul,\n  \"c\": 1\n}"
+	for _, tokenizer := range []interface {
+		Next() (jsontools.TokenType, []byte, error)
+	}{
+		jsontools.NewJsonTokenizer([]byte(src)),
+		jsontools.NewJsonReaderTokenizer(iotest.OneByteReader(strings.NewReader(src))),
+	} {
+		var err error
+		for err == nil {
+			_, _, err = tokenizer.Next()
+		}
+		var syntaxErr *jsontools.SyntaxError
+		require.ErrorAs(t, err, &syntaxErr)
+		require.Equal(t, int64(35), syntaxErr.Offset)
+		require.Equal(t, 3, syntaxErr.Line)
+		require.Equal(t, 8, syntaxErr.Column)
+		require.Contains(t, syntaxErr.Excerpt, "nul,")
+	}
+}
+
+func TestTokenizerNumber(t *testing.T) {
+	cases := []struct {
+		src   string
+		token jsontools.TokenType
+	}{
+		{`0`, jsontools.Number},
+		{`-0`, jsontools.Number},
+		{`1234567890`, jsontools.Number},
+		{`-1234567890`, jsontools.Number},
+		{`0.5`, jsontools.Float},
+		{`-1.25`, jsontools.Float},
+		{`1e10`, jsontools.Float},
+		{`1E10`, jsontools.Float},
+		{`2.5E-3`, jsontools.Float},
+		{`-2.5e+3`, jsontools.Float},
+		{`0e0`, jsontools.Float},
+	}
+	for _, c := range cases {
+		for _, suffix := range []string{"", ",", "]", " "} {
+			tokenizer := jsontools.NewJsonTokenizer([]byte(c.src + suffix))
+			token, value, err := tokenizer.Next()
+			require.NoError(t, err, c.src)
+			require.Equal(t, c.token, token, c.src)
+			require.Equal(t, c.src, string(value))
+		}
+	}
+
+	invalid := []string{`-`, `01`, `-01`, `00`, `1.`, `-.5`, `1.e3`, `1e`, `1e+`, `1E-`, `1.2.3`, `1e2e3`, `--1`, `1+`, `1.5-`}
+	for _, src := range invalid {
+		for _, suffix := range []string{"", ",", "]", " "} {
+			tokenizer := jsontools.NewJsonTokenizer([]byte(src + suffix))
+			var err error
+			for err == nil {
+				var token jsontools.TokenType
+				token, _, err = tokenizer.Next()
+				if token == jsontools.EndJson {
+					break
+				}
+			}
+			require.ErrorIs(t, err, jsontools.ErrInvalidNumber, src+suffix)
+		}
+	}
+}
+
+type tokenValue struct {
+	token jsontools.TokenType
+	value string
+}
+
+func collectTokens(t *testing.T, next func() (jsontools.TokenType, []byte, error)) []tokenValue {
+	var tokens []tokenValue
+	for {
+		token, value, err := next()
+		require.NoError(t, err)
+		tokens = append(tokens, tokenValue{token, string(value)})
+		if token == jsontools.EndJson {
+			return tokens
+		}
+	}
+}
+
+func TestReaderTokenizer(t *testing.T) {
+	long := `{"long":"` + strings.Repeat("😄\\\"", 2000) + `","n":-1234567.890,"t":true,"f":false,"z":null}`
+	for _, src := range []string{expected1, long, `[1,2,3]`, `"1\\"`, `1`, ``} {
+		expected := collectTokens(t, jsontools.NewJsonTokenizer([]byte(src)).Next)
+
+		readers := []io.Reader{
+			strings.NewReader(src),
+			iotest.OneByteReader(strings.NewReader(src)),
+			iotest.HalfReader(strings.NewReader(src)),
+			iotest.DataErrReader(strings.NewReader(src)),
+		}
+		for _, r := range readers {
+			require.Equal(t, expected, collectTokens(t, jsontools.NewJsonReaderTokenizer(r).Next))
+		}
+	}
+}
+
+func TestReaderTokenizerError(t *testing.T) {
+	errRead := errors.New("read error")
+	// the read error stops the input in the middle of a token
+	for _, c := range []string{`{"a":1,`, `{"a":1`, `{"a":"abc`, `{"a":"ab\`, `{"a":tr`, `{"a":fal`, `{"a":n`} {
+		r := io.MultiReader(strings.NewReader(c), iotest.ErrReader(errRead))
+		tokenizer := jsontools.NewJsonReaderTokenizer(r)
+		var err error
+		for err == nil {
+			_, _, err = tokenizer.Next()
+		}
+		require.Equal(t, errRead, err, c)
+	}
+
+	// a malformed literal is still a syntax error
+	r := io.MultiReader(strings.NewReader(`{"a":trx`), iotest.ErrReader(errRead))
+	tokenizer := jsontools.NewJsonReaderTokenizer(r)
+	var err error
+	for err == nil {
+		_, _, err = tokenizer.Next()
+	}
+	require.ErrorIs(t, err, jsontools.ErrInvalidToken)
+
+	for _, c := range []string{`tru`, `"1`, `nul`} {
+		tokenizer := jsontools.NewJsonReaderTokenizer(iotest.OneByteReader(strings.NewReader(c)))
+		_, _, err := tokenizer.Next()
 		require.Error(t, err)
-		require.Equal(t, c.expected, err.Error())
 	}
 }
 
@@ -135,3 +293,17 @@ func BenchmarkTokenizer(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkReaderTokenizer(b *testing.B) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tokenizer := jsontools.NewJsonReaderTokenizer(strings.NewReader(expected1))
+		for {
+			token, _, err := tokenizer.Next()
+			require.NoError(b, err)
+			if token == jsontools.EndJson {
+				break
+			}
+		}
+	}
+}